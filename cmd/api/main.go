@@ -2,25 +2,41 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"go_postgres/internal/auth"
+	"go_postgres/internal/clock"
 	"go_postgres/internal/config"
 	"go_postgres/internal/db"
 	"go_postgres/internal/db/migrations"
 	"go_postgres/internal/handlers"
+	"go_postgres/internal/idgen"
+	"go_postgres/internal/jobs"
+	"go_postgres/internal/lockout"
+	"go_postgres/internal/mailer"
 	"go_postgres/internal/middleware"
+	"go_postgres/internal/models"
 	"go_postgres/internal/repository"
+	"go_postgres/internal/secretbox"
 	"go_postgres/internal/service"
+	"go_postgres/internal/version"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 func main() {
+	migrateCommand := flag.String("migrate", "", "run a schema command and exit instead of serving: up, down, version or force")
+	migrateSteps := flag.Int("steps", 1, "number of migrations -migrate down rolls back")
+	forceVersion := flag.Int("version", -2, "schema version -migrate force records as clean")
+	flag.Parse()
+
 	// Load configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -32,35 +48,118 @@ func main() {
 	logger := initLogger(cfg.Logger)
 	defer logger.Sync()
 
-	// Run database migrations
-	logger.Info("Running database migrations...")
-	if err := migrations.RunMigrations(cfg.DB.GetDSN()); err != nil {
-		logger.Fatal("Failed to run database migrations", zap.Error(err))
+	if *migrateCommand != "" {
+		if err := runMigrateCommand(cfg, logger, *migrateCommand, *migrateSteps, *forceVersion); err != nil {
+			logger.Fatal("Migration command failed", zap.String("command", *migrateCommand), zap.Error(err))
+		}
+		return
 	}
 
-	// Connect to the database
-	db, err := db.NewPostgresDB(&cfg.DB, logger)
+	// Connect to the database first; migrations need it to be up as well, and it
+	// may still be starting (e.g. under docker-compose)
+	connectCtx, cancelConnect := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	db, err := db.NewPostgresDB(connectCtx, &cfg.DB, logger)
+	cancelConnect()
 	if err != nil {
 		logger.Fatal("Failed to connect to database", zap.Error(err))
 	}
 
+	// Run database migrations, unless they're managed outside the app
+	migrationStatus := "skipped"
+	if cfg.DB.RunMigrations {
+		logger.Info("Running database migrations...")
+		if err := migrations.RunMigrations(cfg.DB.GetMigrationURL()); err != nil {
+			logger.Fatal("Failed to run database migrations", zap.Error(err))
+		}
+		migrationStatus = "applied"
+	} else {
+		logger.Info("Skipping database migrations (RUN_MIGRATIONS=false)")
+	}
+
+	// Refuse to serve against a schema older than the code expects
+	if err := migrations.CheckVersion(cfg.DB.GetMigrationURL()); err != nil {
+		logger.Fatal("Database schema is incompatible with this version", zap.Error(err))
+	}
+
+	// Configure ID assignment for new records
+	idGenerator, err := idgen.New(cfg.App.IDGenerator, cfg.App.NodeID, clock.Real{})
+	if err != nil {
+		logger.Fatal("Failed to create ID generator", zap.Error(err))
+	}
+	models.SetIDGenerator(idGenerator)
+
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db.DB, logger)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db.DB, logger)
+	passwordHistoryRepo := repository.NewPasswordHistoryRepository(db.DB, logger)
+	backupCodeRepo := repository.NewBackupCodeRepository(db.DB, logger)
+	txManager := repository.NewTxManager(db.DB, logger)
+	emailVerificationRepo := repository.NewEmailVerificationRepository(db.DB, logger)
+	passwordResetRepo := repository.NewPasswordResetRepository(db.DB, logger)
+	auditRepo := repository.NewAuditRepository(db.DB, logger)
 
 	// Initialize services
-	userService := service.NewUserService(userRepo, logger)
+	lockouts := lockout.NewTracker(clock.Real{}, cfg.Auth.MaxFailedAttempts, cfg.Auth.LockoutDuration)
+	mail := mailer.New(&cfg.Mail, logger, cfg.App.Environment == "development")
+	verificationService := service.NewVerificationService(userRepo, emailVerificationRepo, txManager, mail, logger, clock.Real{}, &cfg.Auth, &cfg.App)
+	userService := service.NewUserService(userRepo, passwordHistoryRepo, refreshTokenRepo, txManager, verificationService, passwordResetRepo, mail, auditRepo, logger, clock.Real{}, &cfg.App, &cfg.Auth, lockouts)
+	tokenService := service.NewTokenService(refreshTokenRepo, logger, clock.Real{}, &cfg.Auth)
+
+	// TOTP secrets are encrypted at rest; without a key two-factor stays unavailable
+	var totpBox *secretbox.Box
+	if cfg.Auth.TOTPEncryptionKey != "" {
+		totpBox, err = secretbox.New(cfg.Auth.TOTPEncryptionKey)
+		if err != nil {
+			logger.Fatal("Failed to load TOTP encryption key", zap.Error(err))
+		}
+	}
+	twoFactorService := service.NewTwoFactorService(userRepo, backupCodeRepo, txManager, totpBox, logger, clock.Real{}, &cfg.Auth, cfg.App.Name, lockouts)
+
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	if cfg.Retention.Enabled {
+		retentionJob := jobs.NewRetentionJob(userRepo, logger, clock.Real{}, &cfg.Retention)
+		go retentionJob.Run(jobsCtx)
+		logger.Info("Retention job enabled",
+			zap.Duration("period", cfg.Retention.Period),
+			zap.Bool("dry_run", cfg.Retention.DryRun),
+		)
+	}
+
+	// Access tokens
+	tokenManager, err := auth.NewTokenManager(&cfg.Auth, clock.Real{})
+	if err != nil {
+		logger.Fatal("Failed to create token manager", zap.Error(err))
+	}
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(userService, logger)
+	userHandler := handlers.NewUserHandler(userService, tokenService, twoFactorService, verificationService, tokenManager, logger, &cfg.API, &cfg.Auth)
+	healthHandler := handlers.NewHealthHandler(db, logger, clock.Real{}, &cfg.Health)
 
 	// Set up routes
 	mux := http.NewServeMux()
 
+	// Health checks; GET patterns also match HEAD
+	mux.HandleFunc("GET /healthz", healthHandler.Liveness)
+	mux.HandleFunc("GET /readyz", healthHandler.Readiness)
+	mux.HandleFunc("POST /api/admin/drain", healthHandler.Drain)
+
 	// Public routes
 	mux.HandleFunc("POST /api/auth/login", userHandler.AuthenticateUser)
+	mux.HandleFunc("POST /api/auth/refresh", userHandler.RefreshToken)
+	mux.HandleFunc("POST /api/auth/2fa", userHandler.CompleteTwoFactorLogin)
+	mux.HandleFunc("GET /api/auth/verify", userHandler.VerifyEmail)
+	mux.HandleFunc("POST /api/auth/forgot-password", userHandler.ForgotPassword)
+	mux.HandleFunc("POST /api/auth/reset-password", userHandler.ResetPassword)
 	mux.HandleFunc("POST /api/users", userHandler.CreateUser)
 
 	// Protected routes
+	if cfg.Auth.DevAuthBypass {
+		logger.Warn("INSECURE: development auth bypass is enabled; any request can act as any user via the X-Dev-User-ID header")
+	}
+	authenticate := middleware.AuthMiddleware(tokenManager, &cfg.Auth, logger)
 	authRouter := middleware.RequireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case r.Method == http.MethodGet && r.URL.Path == "/api/users":
@@ -76,13 +175,51 @@ func main() {
 		}
 	}))
 
-	mux.Handle("GET /api/users", authRouter)
-	mux.Handle("GET /api/users/{id}", authRouter)
-	mux.Handle("PUT /api/users/{id}", authRouter)
-	mux.Handle("DELETE /api/users/{id}", authRouter)
+	mux.Handle("GET /api/users", authenticate(authRouter))
+	mux.Handle("GET /api/users/{id}", authenticate(authRouter))
+	mux.Handle("PUT /api/users/{id}", authenticate(authRouter))
+	// Owners may update and delete their own account; the handlers enforce it
+	mux.Handle("DELETE /api/users/{id}", authenticate(authRouter))
+	mux.Handle("GET /api/users/deleted", authenticate(middleware.RequireRole(models.RoleAdmin)(http.HandlerFunc(userHandler.ListDeletedUsers))))
+	mux.Handle("POST /api/users/bulk", authenticate(middleware.RequireRole(models.RoleAdmin)(http.HandlerFunc(userHandler.CreateUsers))))
+	mux.Handle("POST /api/users/{id}/restore", authenticate(middleware.RequireRole(models.RoleAdmin)(http.HandlerFunc(userHandler.RestoreUser))))
+	mux.Handle("GET /api/users/me", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.GetMe))))
+	mux.Handle("PUT /api/users/me", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.UpdateMe))))
+	mux.Handle("DELETE /api/users/me", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.DeleteMe))))
+	mux.Handle("POST /api/users/me/verify-password", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.VerifyPassword))))
+	mux.Handle("POST /api/users/me/2fa/enroll", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.EnrollTwoFactor))))
+	mux.Handle("POST /api/users/me/2fa/verify", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.ConfirmTwoFactor))))
+	mux.Handle("POST /api/users/me/2fa/backup-codes", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.RegenerateBackupCodes))))
+	mux.Handle("POST /api/users/me/2fa/disable", authenticate(middleware.RequireAuthentication(http.HandlerFunc(userHandler.DisableTwoFactor))))
 
 	// Set up middleware
-	handler := middleware.RequestLogger(logger)(mux)
+	// A route budget at or above the write timeout would see the connection
+	// closed before the timeout middleware could respond
+	for route, timeout := range cfg.Server.RouteTimeouts {
+		if timeout >= cfg.Server.WriteTimeout {
+			logger.Warn("Route timeout is not below the server write timeout",
+				zap.String("route", route),
+				zap.Duration("timeout", timeout),
+				zap.Duration("write_timeout", cfg.Server.WriteTimeout),
+			)
+		}
+	}
+	handler := middleware.QueryTags(mux)(mux)
+	handler = middleware.QueryCounter(mux, logger, cfg.DB.QueryCountThreshold)(handler)
+	handler = middleware.Timeout(mux, cfg.Server.RequestTimeout, cfg.Server.RouteTimeouts)(handler)
+	handler = middleware.RouteRateLimits(mux, cfg.RateLimit)(handler)
+	handler = middleware.ServerTiming(cfg.API.ServerTiming)(handler)
+	handler = middleware.SlowRequestSampler(logger, cfg.Slow)(handler)
+	handler = middleware.CORS(cfg.CORS)(handler)
+	handler = middleware.SecurityHeaders(cfg.Headers)(handler)
+	handler = middleware.EnforceHTTPS(cfg.HTTPS)(handler)
+	handler = middleware.RequestLogger(logger)(handler)
+	// Outermost but for RequestID, so even a panic in middleware is answered
+	// and the logged trace carries the request ID
+	handler = middleware.Recover(logger)(handler)
+	handler = middleware.RequestID(handler)
+
+	logStartupSummary(logger, cfg, migrationStatus)
 
 	// Initialize server
 	server := &http.Server{
@@ -107,12 +244,26 @@ func main() {
 
 	// Shutdown server
 	logger.Info("Shutting down server...")
+
+	// Fail readiness first and keep serving while the load balancer catches up;
+	// skipped when a drain was already requested through the admin endpoint
+	if healthHandler.StartDraining() && cfg.Health.DrainDelay > 0 {
+		logger.Info("Draining before shutdown", zap.Duration("delay", cfg.Health.DrainDelay))
+		time.Sleep(cfg.Health.DrainDelay)
+	}
+
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Fatal("Server shutdown failed", zap.Error(err))
 	}
 
+	// Only close the pool once in-flight requests have finished with it
+	if err := db.Close(); err != nil {
+		logger.Error("Failed to close database connections", zap.Error(err))
+	}
+
 	logger.Info("Server gracefully stopped")
 }
 
@@ -123,21 +274,24 @@ func initLogger(cfg config.LoggerConfig) *zap.Logger {
 		level = zapcore.InfoLevel
 	}
 
-	var logger *zap.Logger
-	var err error
-
+	var config zap.Config
 	if cfg.Dev {
 		// Development logger
-		config := zap.NewDevelopmentConfig()
-		config.Level = zap.NewAtomicLevelAt(level)
-		logger, err = config.Build()
+		config = zap.NewDevelopmentConfig()
 	} else {
 		// Production logger
-		config := zap.NewProductionConfig()
-		config.Level = zap.NewAtomicLevelAt(level)
-		logger, err = config.Build()
+		config = zap.NewProductionConfig()
 	}
+	config.Level = zap.NewAtomicLevelAt(level)
 
+	var opts []zap.Option
+	if cfg.Buffered {
+		opts = append(opts, zap.WrapCore(func(zapcore.Core) zapcore.Core {
+			return newBufferedCore(config, cfg)
+		}))
+	}
+
+	logger, err := config.Build(opts...)
 	if err != nil {
 		fmt.Printf("Failed to initialize logger: %v\n", err)
 		os.Exit(1)
@@ -145,3 +299,54 @@ func initLogger(cfg config.LoggerConfig) *zap.Logger {
 
 	return logger
 }
+
+// newBufferedCore builds a core equivalent to the one described by zapConfig
+// but writing to stderr through a buffer that is flushed in batches. This
+// trades a little log latency for far fewer write syscalls under load.
+func newBufferedCore(zapConfig zap.Config, cfg config.LoggerConfig) zapcore.Core {
+	encoder := zapcore.NewJSONEncoder(zapConfig.EncoderConfig)
+	if zapConfig.Encoding == "console" {
+		encoder = zapcore.NewConsoleEncoder(zapConfig.EncoderConfig)
+	}
+
+	writer := &zapcore.BufferedWriteSyncer{
+		WS:            zapcore.Lock(os.Stderr),
+		Size:          cfg.BufferSize,
+		FlushInterval: cfg.FlushInterval,
+	}
+
+	core := zapcore.NewCore(encoder, writer, zapConfig.Level)
+	if zapConfig.Sampling != nil {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, zapConfig.Sampling.Initial, zapConfig.Sampling.Thereafter)
+	}
+	return core
+}
+
+// logStartupSummary logs the effective configuration in a single line so
+// misconfiguration is easy to spot; secrets are redacted
+func logStartupSummary(logger *zap.Logger, cfg *config.Config, migrationStatus string) {
+	safe := cfg.Redacted()
+	logger.Info("Startup summary",
+		zap.String("version", version.Version),
+		zap.String("environment", safe.App.Environment),
+		zap.String("port", safe.Server.Port),
+		zap.String("db_host", safe.DB.Host),
+		zap.String("db_port", safe.DB.Port),
+		zap.String("db_name", safe.DB.DBName),
+		zap.String("db_user", safe.DB.User),
+		zap.String("db_password", safe.DB.Password),
+		zap.Int("db_max_open_conns", safe.DB.MaxOpenConns),
+		zap.Int("db_max_idle_conns", safe.DB.MaxIdleConns),
+		zap.String("log_level", safe.Logger.Level),
+		zap.String("migrations", migrationStatus),
+		zap.String("id_generator", safe.App.IDGenerator),
+		zap.String("activation_mode", safe.App.ActivationMode),
+		zap.String("error_format", safe.API.ErrorFormat),
+		zap.Bool("query_tagging", safe.DB.QueryTagging),
+		zap.Bool("retention_job", safe.Retention.Enabled),
+		zap.Bool("https_enforced", safe.HTTPS.Enforce),
+		zap.Bool("slow_request_sampler", safe.Slow.Enabled),
+		zap.Int("rate_limited_routes", len(safe.RateLimit.Routes)),
+		zap.Bool("buffered_logging", safe.Logger.Buffered),
+	)
+}
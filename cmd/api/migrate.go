@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"go_postgres/internal/config"
+	"go_postgres/internal/db/migrations"
+
+	"go.uber.org/zap"
+)
+
+// Subcommands accepted by -migrate
+const (
+	migrateUp      = "up"
+	migrateDown    = "down"
+	migrateVersion = "version"
+	migrateForce   = "force"
+)
+
+// runMigrateCommand performs a one-off schema operation instead of starting
+// the server, e.g. rolling back the last migration after a bad deploy
+func runMigrateCommand(cfg *config.Config, logger *zap.Logger, command string, steps, forceVersion int) error {
+	dsn := cfg.DB.GetMigrationURL()
+
+	switch command {
+	case migrateUp:
+		if err := migrations.RunMigrations(dsn); err != nil {
+			return err
+		}
+	case migrateDown:
+		if err := migrations.RollbackMigrations(dsn, steps); err != nil {
+			return err
+		}
+		logger.Info("Rolled back migrations", zap.Int("steps", steps))
+	case migrateForce:
+		if forceVersion < -1 {
+			return fmt.Errorf("-migrate %s requires -version", migrateForce)
+		}
+		if err := migrations.ForceVersion(dsn, forceVersion); err != nil {
+			return err
+		}
+		logger.Warn("Forced schema version; no migrations were run", zap.Int("version", forceVersion))
+	case migrateVersion:
+	default:
+		return fmt.Errorf("unknown migrate command %q; use %s, %s, %s or %s", command, migrateUp, migrateDown, migrateVersion, migrateForce)
+	}
+
+	version, dirty, err := migrations.SchemaVersion(dsn)
+	if err != nil {
+		return err
+	}
+	logger.Info("Database schema version",
+		zap.Uint("version", version),
+		zap.Bool("dirty", dirty),
+		zap.Uint("required", migrations.MinSchemaVersion),
+	)
+	return nil
+}
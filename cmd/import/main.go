@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"go_postgres/internal/config"
+	"go_postgres/internal/db"
+	"go_postgres/internal/importer"
+
+	"go.uber.org/zap"
+)
+
+// import loads users from a CSV file with the header
+// username,email,password_hash,first_name,last_name
+func main() {
+	file := flag.String("file", "", "path to the CSV file to import")
+	batchSize := flag.Int("batch-size", 50000, "number of rows copied per transaction")
+	flag.Parse()
+
+	if *file == "" || *batchSize < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		fmt.Printf("Failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	// Connect to the database
+	connectCtx, cancelConnect := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	database, err := db.NewPostgresDB(connectCtx, &cfg.DB, logger)
+	cancelConnect()
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		logger.Fatal("Failed to open import file", zap.Error(err))
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err != nil {
+		logger.Fatal("Failed to read CSV header", zap.Error(err))
+	}
+	if !slices.Equal(header, importer.Columns) {
+		logger.Fatal("Unexpected CSV header", zap.Strings("header", header), zap.Strings("expected", importer.Columns))
+	}
+
+	bulkImporter := importer.NewBulkImporter(database.DB, logger)
+	ctx := context.Background()
+
+	var total importer.Result
+	batch := make([]importer.Record, 0, *batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		result, err := bulkImporter.Import(ctx, batch)
+		if err != nil {
+			logger.Fatal("Import failed", zap.Error(err))
+		}
+		total.Staged += result.Staged
+		total.Inserted += result.Inserted
+		total.Skipped += result.Skipped
+		batch = batch[:0]
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.Fatal("Failed to read CSV row", zap.Error(err))
+		}
+
+		batch = append(batch, importer.Record{
+			Username:     row[0],
+			Email:        row[1],
+			PasswordHash: row[2],
+			FirstName:    row[3],
+			LastName:     row[4],
+		})
+		if len(batch) == *batchSize {
+			flush()
+		}
+	}
+	flush()
+
+	logger.Info("Import complete",
+		zap.Int64("rows", total.Staged),
+		zap.Int64("inserted", total.Inserted),
+		zap.Int64("skipped", total.Skipped),
+	)
+}
@@ -0,0 +1,15 @@
+package clock
+
+import "time"
+
+// Clock abstracts the current time so it can be controlled in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is a Clock backed by the system time
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
@@ -1,23 +1,233 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"go_postgres/internal/version"
+
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Error response formats supported by the API
+const (
+	ErrorFormatSimple  = "simple"
+	ErrorFormatProblem = "problem"
+)
+
+// Account activation modes, deciding whether new users can log in right away
+const (
+	ActivationAlwaysActive        = "always-active"
+	ActivationRequireVerification = "require-verification"
+	ActivationRequireApproval     = "require-approval"
 )
 
 type Config struct {
-	Server ServerConfig
-	DB     DatabaseConfig
-	Logger LoggerConfig
-	App    AppConfig
+	Server    ServerConfig
+	DB        DatabaseConfig
+	Logger    LoggerConfig
+	App       AppConfig
+	API       APIConfig
+	CORS      CORSConfig
+	Retention RetentionConfig
+	HTTPS     HTTPSConfig
+	Headers   SecurityHeadersConfig
+	Slow      SlowRequestConfig
+	RateLimit RateLimitConfig
+	Mail      MailConfig
+	Health    HealthConfig
+	Auth      AuthConfig
 }
 
 type AppConfig struct {
+	Name        string
 	Environment string
+	// IDGenerator selects how new records get their IDs: "sequence" or "snowflake"
+	IDGenerator string
+	// NodeID distinguishes replicas when generating Snowflake IDs
+	NodeID int64
+	// ActivationMode decides whether new accounts start active: "always-active",
+	// "require-verification" (after confirming their email) or "require-approval"
+	// (by an administrator)
+	ActivationMode string
+	// BaseURL is the public address of the API, used to build links sent by email
+	BaseURL string
+	// PasswordResetURL is the page, usually in the frontend, that the emailed
+	// reset link opens; the token is appended as ?token=
+	PasswordResetURL string
+}
+
+// MailConfig holds the SMTP settings for outgoing email. Without a host, mail
+// is written to the log in development and not sent at all elsewhere.
+type MailConfig struct {
+	SMTPHost string
+	SMTPPort string
+	Username string
+	Password string
+	From     string
+}
+
+type APIConfig struct {
+	// ErrorFormat selects the error envelope: "simple" ({"error": {"code": "...", "message": "..."}}) or
+	// "problem" (RFC 7807 application/problem+json)
+	ErrorFormat string
+	// ProblemTypeBaseURI is prefixed to the error code to build the problem "type"
+	ProblemTypeBaseURI string
+	// MaxOffset is the deepest row offset offset-based pagination may request
+	MaxOffset int
+	// MaxPageSize is the largest page_size list endpoints accept
+	MaxPageSize int
+	// MaxJSONDepth is how deeply objects and arrays may nest in request bodies
+	MaxJSONDepth int
+	// MaxBodyBytes caps the size of JSON request bodies
+	MaxBodyBytes int64
+	// StrictPagination rejects pages past the last one with a 400 instead of
+	// returning them empty
+	StrictPagination bool
+	// ServerTiming adds a Server-Timing header with the auth/db/app breakdown.
+	// It reveals internals, so it defaults to on only in development.
+	ServerTiming bool
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+	// Routes overrides the allowed methods/headers for paths under a prefix;
+	// the longest matching prefix wins
+	Routes map[string]CORSRouteConfig
+}
+
+type CORSRouteConfig struct {
+	AllowedMethods []string
+	AllowedHeaders []string
+}
+
+// RetentionConfig controls the job that anonymizes long-inactive accounts
+type RetentionConfig struct {
+	Enabled bool
+	// DryRun only logs the accounts that would be anonymized
+	DryRun    bool
+	Period    time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+// HTTPSConfig controls HTTPS enforcement behind a TLS-terminating proxy
+type HTTPSConfig struct {
+	Enforce               bool
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// ExemptPaths are served over plain HTTP, e.g. health checks from the orchestrator
+	ExemptPaths []string
+}
+
+// SecurityHeadersConfig holds the hardening headers added to every response;
+// an empty value leaves that header unset
+type SecurityHeadersConfig struct {
+	NoSniff               bool
+	FrameOptions          string
+	ReferrerPolicy        string
+	ContentSecurityPolicy string
+}
+
+// SlowRequestConfig controls logging of per-request timing breakdowns
+type SlowRequestConfig struct {
+	Enabled bool
+	// Threshold is the duration above which every request is logged
+	Threshold time.Duration
+	// SampleRate is the fraction (0-1) of faster requests logged anyway
+	SampleRate float64
+}
+
+// RateLimitConfig throttles requests per client IP
+type RateLimitConfig struct {
+	// Routes maps a route pattern, e.g. "POST /api/auth/login", to its limit;
+	// routes not listed aren't limited
+	Routes map[string]RateLimitRule
+}
+
+// RateLimitRule is a token bucket refilling at RPS tokens per second and
+// holding up to Burst tokens
+type RateLimitRule struct {
+	RPS   float64
+	Burst int
+}
+
+// PasswordPolicyConfig holds the rules new passwords must satisfy
+type PasswordPolicyConfig struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectCommon refuses passwords found on a list of commonly used ones
+	RejectCommon bool
+}
+
+// AuthConfig holds the access token settings
+type AuthConfig struct {
+	// JWTSecret signs access tokens; it must be set for the server to start,
+	// and be at least MinJWTSecretLength bytes outside development
+	JWTSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Issuer and Audience fill the iss and aud claims of access tokens, and
+	// tokens without the same values are rejected; empty leaves a claim out
+	Issuer   string
+	Audience string
+	// DevAuthBypass lets requests authenticate with an X-Dev-User-ID header
+	// instead of a token. Only allowed when ENVIRONMENT=development.
+	DevAuthBypass bool
+	// MaxFailedAttempts consecutive wrong passwords lock an account for
+	// LockoutDuration; 0 disables locking
+	MaxFailedAttempts int
+	LockoutDuration   time.Duration
+	// PasswordHistory is how many recent passwords, including the current one,
+	// can't be reused; 0 disables the check
+	PasswordHistory int
+	PasswordPolicy  PasswordPolicyConfig
+	// TOTPEncryptionKey is a base64-encoded 32-byte key that encrypts TOTP
+	// secrets at rest; two-factor authentication is unavailable without it
+	TOTPEncryptionKey string
+	// TOTPSkew is how many 30 second steps either side of now a code may be from
+	TOTPSkew int
+	// TwoFactorChallengeTTL is how long a client has to enter the code after the password
+	TwoFactorChallengeTTL time.Duration
+	// EmailVerificationTTL is how long an email verification link stays valid
+	EmailVerificationTTL time.Duration
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL time.Duration
+	// BackupCodeCount is how many one-time recovery codes each generation yields
+	BackupCodeCount int
+	// BcryptCost is the work factor for new password hashes. Raising it
+	// upgrades existing hashes as their users next log in.
+	BcryptCost int
+}
+
+// HealthConfig controls the liveness and readiness endpoints
+type HealthConfig struct {
+	// Detailed includes uptime, version and goroutine count in /healthz by default
+	Detailed bool
+	// DrainToken authorizes POST /api/admin/drain; the endpoint is disabled when empty
+	DrainToken string
+	// DrainDelay is how long shutdown keeps serving after /readyz starts failing,
+	// giving load balancers time to stop routing new requests
+	DrainDelay time.Duration
+	// ReadyTimeout bounds the database ping made by /readyz
+	ReadyTimeout time.Duration
 }
 
 type ServerConfig struct {
@@ -25,6 +235,10 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	// RequestTimeout bounds handler execution for routes without an override
+	RequestTimeout time.Duration
+	// RouteTimeouts overrides RequestTimeout per route pattern, e.g. "POST /api/auth/login"
+	RouteTimeouts map[string]time.Duration
 }
 
 type DatabaseConfig struct {
@@ -37,69 +251,441 @@ type DatabaseConfig struct {
 	MaxOpenConns int
 	MaxIdleConns int
 	ConnMaxLife  time.Duration
+	// ApplicationName labels the connections in pg_stat_activity
+	ApplicationName string
+	// QueryTagging appends request tags as a SQL comment to every query
+	QueryTagging bool
+	// RunMigrations applies pending migrations on startup; disable when the
+	// schema is managed externally
+	RunMigrations bool
+	// QueryCountThreshold is the number of queries a single request may issue
+	// before a likely N+1 pattern is logged; 0 disables counting. Only honored
+	// in development.
+	QueryCountThreshold int
+	// AuditSoftDelete warns about queries on soft-deleted tables that don't
+	// filter on deleted_at. Only honored in development.
+	AuditSoftDelete bool
+	// ConnectRetries is how many times to retry connecting on startup, waiting
+	// ConnectBackoff and doubling it after each failure, for at most ConnectTimeout
+	ConnectRetries int
+	ConnectBackoff time.Duration
+	ConnectTimeout time.Duration
+	// QueryTimeout cancels any single statement running longer; 0 leaves
+	// statements bounded only by the request
+	QueryTimeout time.Duration
+	// PoolStatsInterval is how often connection pool statistics are logged;
+	// 0 disables the logging
+	PoolStatsInterval time.Duration
 }
 
 type LoggerConfig struct {
 	Level string
 	Dev   bool
+	// Buffered batches log writes, flushing when the buffer fills or every FlushInterval
+	Buffered      bool
+	BufferSize    int
+	FlushInterval time.Duration
 }
 
 func LoadConfig() (*Config, error) {
 	_ = godotenv.Load()
 
+	// Malformed values are collected and reported together rather than
+	// silently becoming zero
+	env := &envParser{}
+
+	environment := getEnv("ENVIRONMENT", "development")
+
 	serverPort := getEnv("SERVER_PORT", "8000")
-	readTimeout, _ := strconv.Atoi(getEnv("SERVER_READ_TIMEOUT", "5"))
-	writeTimeout, _ := strconv.Atoi(getEnv("SERVER_WRITE_TIMEOUT", "10"))
-	shutdownTimeout, _ := strconv.Atoi(getEnv("SERVER_SHUTDOWN_TIMEOUT", "5"))
+	readTimeout := env.Int("SERVER_READ_TIMEOUT", "5")
+	writeTimeout := env.Int("SERVER_WRITE_TIMEOUT", "10")
+	shutdownTimeout := env.Int("SERVER_SHUTDOWN_TIMEOUT", "5")
+	requestTimeout := env.Int("SERVER_REQUEST_TIMEOUT", "5")
+	// Routes that hash passwords with bcrypt need a bigger budget than reads
+	routeTimeouts := env.DurationMap("SERVER_ROUTE_TIMEOUTS", "POST /api/auth/login=9,POST /api/users=9", time.Second)
 
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
 	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
+	// The well-known default is only a convenience for local development
+	dbPasswordDefault := ""
+	if environment == "development" {
+		dbPasswordDefault = "postgres"
+	}
+	dbPassword := getEnv("DB_PASSWORD", dbPasswordDefault)
 	dbName := getEnv("DB_NAME", "app_db")
 	dbSSLMode := getEnv("DB_SSL_MODE", "disable")
-	dbMaxOpenConns, _ := strconv.Atoi(getEnv("DB_MAX_OPEN_CONNS", "25"))
-	dbMaxIdleConns, _ := strconv.Atoi(getEnv("DB_MAX_IDLE_CONNS", "25"))
-	dbConnMaxLife, _ := strconv.Atoi(getEnv("DB_CONN_MAX_LIFETIME", "5"))
+	dbMaxOpenConns := env.Int("DB_MAX_OPEN_CONNS", "25")
+	// Idle connections each hold a server backend for every replica; keep only
+	// enough warm to absorb ordinary bursts and let the rest close
+	dbMaxIdleConns := env.Int("DB_MAX_IDLE_CONNS", "5")
+	dbConnMaxLife := env.Int("DB_CONN_MAX_LIFETIME", "5")
+	dbQueryTagging := env.Bool("DB_QUERY_TAGGING", "false")
+	dbRunMigrations := env.Bool("RUN_MIGRATIONS", "true")
+	dbQueryCountThreshold := env.Int("DB_QUERY_COUNT_THRESHOLD", "10")
+	dbAuditSoftDelete := env.Bool("DB_AUDIT_SOFT_DELETE", "true")
+	dbConnectRetries := env.Int("DB_CONNECT_RETRIES", "5")
+	dbConnectBackoff := env.Int("DB_CONNECT_BACKOFF", "500")
+	dbConnectTimeout := env.Int("DB_CONNECT_TIMEOUT", "60")
+	dbQueryTimeout := env.Int("DB_QUERY_TIMEOUT", "5000")
+	dbPoolStatsInterval := env.Int("DB_POOL_STATS_INTERVAL", "0")
 
 	logLevel := getEnv("LOG_LEVEL", "info")
-	logDev, _ := strconv.ParseBool(getEnv("LOG_DEV", "false"))
+	logDev := env.Bool("LOG_DEV", "false")
+	logBuffered := env.Bool("LOG_BUFFERED", "false")
+	logBufferSize := env.Int("LOG_BUFFER_SIZE_KB", "256")
+	logFlushInterval := env.Int("LOG_FLUSH_INTERVAL", "1")
 
-	environment := getEnv("ENVIRONMENT", "development")
+	appName := getEnv("APP_NAME", "go_postgres")
+	dbApplicationName := getEnv("DB_APPLICATION_NAME", appName+"/"+version.Version)
+
+	idGenerator := getEnv("ID_GENERATOR", "sequence")
+	nodeID := env.Int64("ID_NODE_ID", "0")
+	activationMode := getEnv("ACCOUNT_ACTIVATION_MODE", ActivationAlwaysActive)
+	baseURL := strings.TrimSuffix(getEnv("APP_BASE_URL", "http://localhost:"+serverPort), "/")
+	passwordResetURL := getEnv("PASSWORD_RESET_URL", baseURL+"/reset-password")
+
+	mailSMTPHost := getEnv("MAIL_SMTP_HOST", "")
+	mailSMTPPort := getEnv("MAIL_SMTP_PORT", "587")
+	mailUsername := getEnv("MAIL_USERNAME", "")
+	mailPassword := getEnv("MAIL_PASSWORD", "")
+	mailFrom := getEnv("MAIL_FROM", "no-reply@localhost")
+
+	// Inspecting every query is a development aid, not something to pay for in production
+	if environment != "development" {
+		dbQueryCountThreshold = 0
+		dbAuditSoftDelete = false
+	}
+
+	corsAllowedOrigins := getEnvList("CORS_ALLOWED_ORIGINS", "*")
+	corsAllowedMethods := getEnvList("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE")
+	corsAllowedHeaders := getEnvList("CORS_ALLOWED_HEADERS", "Authorization,Content-Type")
+	corsAllowCredentials := env.Bool("CORS_ALLOW_CREDENTIALS", "false")
+	corsMaxAge := env.Int("CORS_MAX_AGE", "600")
+
+	retentionEnabled := env.Bool("RETENTION_ENABLED", "false")
+	retentionDryRun := env.Bool("RETENTION_DRY_RUN", "false")
+	retentionPeriod := env.Int("RETENTION_PERIOD_DAYS", "730")
+	retentionInterval := env.Int("RETENTION_INTERVAL_HOURS", "24")
+	retentionBatchSize := env.Int("RETENTION_BATCH_SIZE", "100")
+
+	httpsEnforce := env.Bool("HTTPS_ENFORCE", "false")
+	hstsMaxAge := env.Int("HSTS_MAX_AGE", "31536000")
+	hstsIncludeSubdomains := env.Bool("HSTS_INCLUDE_SUBDOMAINS", "false")
+	httpsExemptPaths := getEnvList("HTTPS_EXEMPT_PATHS", "/healthz,/readyz")
+
+	headersNoSniff := env.Bool("SECURITY_NOSNIFF", "true")
+	headersFrameOptions := getEnv("SECURITY_FRAME_OPTIONS", "DENY")
+	headersReferrerPolicy := getEnv("SECURITY_REFERRER_POLICY", "no-referrer")
+	headersCSP := getEnv("SECURITY_CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'")
+
+	slowEnabled := env.Bool("SLOW_REQUEST_ENABLED", "false")
+	slowThreshold := env.Int("SLOW_REQUEST_THRESHOLD_MS", "500")
+	slowSampleRate := env.Float("SLOW_REQUEST_SAMPLE_RATE", "0")
+
+	// Endpoints that check credentials are the targets of credential stuffing
+	rateLimits := env.RateLimits("RATE_LIMITS", "POST /api/auth/login=1:5,POST /api/auth/2fa=1:5,POST /api/auth/forgot-password=1:5,POST /api/auth/reset-password=1:5")
+
+	jwtSecret := getEnv("JWT_SECRET", "")
+	jwtIssuer := getEnv("JWT_ISSUER", appName)
+	jwtAudience := getEnv("JWT_AUDIENCE", "")
+	accessTokenTTL := env.Int("ACCESS_TOKEN_TTL_MINUTES", "15")
+	refreshTokenTTL := env.Int("REFRESH_TOKEN_TTL_HOURS", "720")
+	devAuthBypass := env.Bool("DEV_AUTH_BYPASS", "false")
+	maxFailedAttempts := env.Int("AUTH_MAX_FAILED_ATTEMPTS", "5")
+	lockoutDuration := env.Int("AUTH_LOCKOUT_MINUTES", "15")
+	passwordHistory := env.Int("AUTH_PASSWORD_HISTORY", "5")
+	passwordMinLength := env.Int("PASSWORD_MIN_LENGTH", "8")
+	passwordRequireUpper := env.Bool("PASSWORD_REQUIRE_UPPER", "false")
+	passwordRequireLower := env.Bool("PASSWORD_REQUIRE_LOWER", "false")
+	passwordRequireDigit := env.Bool("PASSWORD_REQUIRE_DIGIT", "false")
+	passwordRequireSymbol := env.Bool("PASSWORD_REQUIRE_SYMBOL", "false")
+	passwordRejectCommon := env.Bool("PASSWORD_REJECT_COMMON", "true")
+	totpEncryptionKey := getEnv("TOTP_ENCRYPTION_KEY", "")
+	totpSkew := env.Int("TOTP_SKEW", "1")
+	twoFactorChallengeTTL := env.Int("TWO_FACTOR_CHALLENGE_TTL_MINUTES", "5")
+	backupCodeCount := env.Int("TWO_FACTOR_BACKUP_CODES", "10")
+	emailVerificationTTL := env.Int("EMAIL_VERIFICATION_TTL_HOURS", "24")
+	passwordResetTTL := env.Int("PASSWORD_RESET_TTL_MINUTES", "60")
+	bcryptCost := env.Int("BCRYPT_COST", strconv.Itoa(bcrypt.DefaultCost))
 
-	return &Config{
+	healthDetailed := env.Bool("HEALTH_DETAILED", "false")
+	drainToken := getEnv("DRAIN_TOKEN", "")
+	drainDelay := env.Int("DRAIN_DELAY_SECONDS", "5")
+	readyTimeout := env.Int("READY_TIMEOUT_MS", "1000")
+
+	errorFormat := getEnv("API_ERROR_FORMAT", ErrorFormatSimple)
+	problemTypeBaseURI := getEnv("API_PROBLEM_TYPE_BASE_URI", "/problems/")
+	maxOffset := env.Int("API_MAX_OFFSET", "100000")
+	maxPageSize := env.Int("API_MAX_PAGE_SIZE", "100")
+	maxJSONDepth := env.Int("API_MAX_JSON_DEPTH", "32")
+	maxBodyBytes := env.Int64("API_MAX_BODY_BYTES", "1048576")
+	strictPagination := env.Bool("API_STRICT_PAGINATION", "false")
+	serverTiming := env.Bool("API_SERVER_TIMING", strconv.FormatBool(environment == "development"))
+
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	cfg := &Config{
 		Server: ServerConfig{
 			Port:            serverPort,
 			ReadTimeout:     time.Duration(readTimeout) * time.Second,
 			WriteTimeout:    time.Duration(writeTimeout) * time.Second,
 			ShutdownTimeout: time.Duration(shutdownTimeout) * time.Second,
+			RequestTimeout:  time.Duration(requestTimeout) * time.Second,
+			RouteTimeouts:   routeTimeouts,
 		},
 
 		DB: DatabaseConfig{
-			Host:         dbHost,
-			Port:         dbPort,
-			User:         dbUser,
-			Password:     dbPassword,
-			DBName:       dbName,
-			SSLMode:      dbSSLMode,
-			MaxOpenConns: dbMaxOpenConns,
-			MaxIdleConns: dbMaxIdleConns,
-			ConnMaxLife:  time.Duration(dbConnMaxLife) * time.Minute,
+			Host:                dbHost,
+			Port:                dbPort,
+			User:                dbUser,
+			Password:            dbPassword,
+			DBName:              dbName,
+			SSLMode:             dbSSLMode,
+			MaxOpenConns:        dbMaxOpenConns,
+			MaxIdleConns:        dbMaxIdleConns,
+			ConnMaxLife:         time.Duration(dbConnMaxLife) * time.Minute,
+			ApplicationName:     dbApplicationName,
+			QueryTagging:        dbQueryTagging,
+			RunMigrations:       dbRunMigrations,
+			QueryCountThreshold: dbQueryCountThreshold,
+			AuditSoftDelete:     dbAuditSoftDelete,
+			ConnectRetries:      dbConnectRetries,
+			ConnectBackoff:      time.Duration(dbConnectBackoff) * time.Millisecond,
+			ConnectTimeout:      time.Duration(dbConnectTimeout) * time.Second,
+			QueryTimeout:        time.Duration(dbQueryTimeout) * time.Millisecond,
+			PoolStatsInterval:   time.Duration(dbPoolStatsInterval) * time.Second,
 		},
 
 		Logger: LoggerConfig{
-			Level: logLevel,
-			Dev:   logDev,
+			Level:         logLevel,
+			Dev:           logDev,
+			Buffered:      logBuffered,
+			BufferSize:    logBufferSize * 1024,
+			FlushInterval: time.Duration(logFlushInterval) * time.Second,
 		},
 
 		App: AppConfig{
-			Environment: environment,
+			Name:             appName,
+			Environment:      environment,
+			IDGenerator:      idGenerator,
+			NodeID:           nodeID,
+			ActivationMode:   activationMode,
+			BaseURL:          baseURL,
+			PasswordResetURL: passwordResetURL,
+		},
+
+		Mail: MailConfig{
+			SMTPHost: mailSMTPHost,
+			SMTPPort: mailSMTPPort,
+			Username: mailUsername,
+			Password: mailPassword,
+			From:     mailFrom,
+		},
+
+		API: APIConfig{
+			ErrorFormat:        errorFormat,
+			ProblemTypeBaseURI: problemTypeBaseURI,
+			MaxOffset:          maxOffset,
+			MaxPageSize:        maxPageSize,
+			MaxJSONDepth:       maxJSONDepth,
+			MaxBodyBytes:       maxBodyBytes,
+			StrictPagination:   strictPagination,
+			ServerTiming:       serverTiming,
 		},
-	}, nil
+
+		CORS: CORSConfig{
+			AllowedOrigins:   corsAllowedOrigins,
+			AllowedMethods:   corsAllowedMethods,
+			AllowedHeaders:   corsAllowedHeaders,
+			AllowCredentials: corsAllowCredentials,
+			MaxAge:           time.Duration(corsMaxAge) * time.Second,
+			Routes: map[string]CORSRouteConfig{
+				// Auth endpoints only accept credentials in the body
+				"/api/auth/": {
+					AllowedMethods: []string{http.MethodPost},
+					AllowedHeaders: []string{"Content-Type"},
+				},
+			},
+		},
+
+		Retention: RetentionConfig{
+			Enabled:   retentionEnabled,
+			DryRun:    retentionDryRun,
+			Period:    time.Duration(retentionPeriod) * 24 * time.Hour,
+			Interval:  time.Duration(retentionInterval) * time.Hour,
+			BatchSize: retentionBatchSize,
+		},
+
+		HTTPS: HTTPSConfig{
+			Enforce:               httpsEnforce,
+			HSTSMaxAge:            time.Duration(hstsMaxAge) * time.Second,
+			HSTSIncludeSubdomains: hstsIncludeSubdomains,
+			ExemptPaths:           httpsExemptPaths,
+		},
+
+		Headers: SecurityHeadersConfig{
+			NoSniff:               headersNoSniff,
+			FrameOptions:          headersFrameOptions,
+			ReferrerPolicy:        headersReferrerPolicy,
+			ContentSecurityPolicy: headersCSP,
+		},
+
+		Slow: SlowRequestConfig{
+			Enabled:    slowEnabled,
+			Threshold:  time.Duration(slowThreshold) * time.Millisecond,
+			SampleRate: slowSampleRate,
+		},
+
+		RateLimit: RateLimitConfig{
+			Routes: rateLimits,
+		},
+
+		Auth: AuthConfig{
+			JWTSecret:         jwtSecret,
+			AccessTokenTTL:    time.Duration(accessTokenTTL) * time.Minute,
+			RefreshTokenTTL:   time.Duration(refreshTokenTTL) * time.Hour,
+			Issuer:            jwtIssuer,
+			Audience:          jwtAudience,
+			DevAuthBypass:     devAuthBypass,
+			MaxFailedAttempts: maxFailedAttempts,
+			LockoutDuration:   time.Duration(lockoutDuration) * time.Minute,
+			PasswordHistory:   passwordHistory,
+			PasswordPolicy: PasswordPolicyConfig{
+				MinLength:     passwordMinLength,
+				RequireUpper:  passwordRequireUpper,
+				RequireLower:  passwordRequireLower,
+				RequireDigit:  passwordRequireDigit,
+				RequireSymbol: passwordRequireSymbol,
+				RejectCommon:  passwordRejectCommon,
+			},
+			TOTPEncryptionKey:     totpEncryptionKey,
+			TOTPSkew:              totpSkew,
+			TwoFactorChallengeTTL: time.Duration(twoFactorChallengeTTL) * time.Minute,
+			BackupCodeCount:       backupCodeCount,
+			EmailVerificationTTL:  time.Duration(emailVerificationTTL) * time.Hour,
+			PasswordResetTTL:      time.Duration(passwordResetTTL) * time.Minute,
+			BcryptCost:            bcryptCost,
+		},
+
+		Health: HealthConfig{
+			Detailed:     healthDetailed,
+			DrainToken:   drainToken,
+			DrainDelay:   time.Duration(drainDelay) * time.Second,
+			ReadyTimeout: time.Duration(readyTimeout) * time.Millisecond,
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// MinJWTSecretLength is the shortest JWT_SECRET accepted outside development:
+// 32 bytes, the output size of the HS256 hash
+const MinJWTSecretLength = 32
+
+// Validate checks that required settings are present and values are within
+// range, reporting every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	development := c.App.Environment == "development"
+	check(development || c.DB.Password != "", "DB_PASSWORD is required when ENVIRONMENT=%s", c.App.Environment)
+	check(development || !c.Auth.DevAuthBypass, "DEV_AUTH_BYPASS is only allowed when ENVIRONMENT=development, not %q", c.App.Environment)
+
+	check(c.Auth.JWTSecret != "", "JWT_SECRET is required")
+	check(development || c.Auth.JWTSecret == "" || len(c.Auth.JWTSecret) >= MinJWTSecretLength,
+		"JWT_SECRET must be at least %d bytes when ENVIRONMENT=%s", MinJWTSecretLength, c.App.Environment)
+
+	check(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT must be positive")
+	check(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT must be positive")
+	check(c.Server.ShutdownTimeout > 0, "SERVER_SHUTDOWN_TIMEOUT must be positive")
+	check(c.DB.ConnectTimeout > 0, "DB_CONNECT_TIMEOUT must be positive")
+	check(c.DB.QueryTimeout >= 0, "DB_QUERY_TIMEOUT must not be negative")
+	check(c.DB.PoolStatsInterval >= 0, "DB_POOL_STATS_INTERVAL must not be negative")
+	check(c.DB.ConnectRetries >= 0, "DB_CONNECT_RETRIES must not be negative")
+	check(c.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(c.Auth.AccessTokenTTL > 0, "ACCESS_TOKEN_TTL_MINUTES must be positive")
+	check(c.Auth.RefreshTokenTTL > 0, "REFRESH_TOKEN_TTL_HOURS must be positive")
+	// bcrypt ignores everything past 72 bytes
+	check(c.Auth.PasswordPolicy.MinLength >= 0 && c.Auth.PasswordPolicy.MinLength <= 72, "PASSWORD_MIN_LENGTH must be between 0 and 72")
+	check(c.Auth.BcryptCost >= bcrypt.MinCost && c.Auth.BcryptCost <= bcrypt.MaxCost, "BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	check(c.API.MaxPageSize > 0, "API_MAX_PAGE_SIZE must be positive")
+	check(c.API.MaxBodyBytes > 0, "API_MAX_BODY_BYTES must be positive")
+	check(c.Slow.SampleRate >= 0 && c.Slow.SampleRate <= 1, "SLOW_REQUEST_SAMPLE_RATE must be between 0 and 1")
+
+	check(slices.Contains([]string{ErrorFormatSimple, ErrorFormatProblem}, c.API.ErrorFormat),
+		"API_ERROR_FORMAT must be %q or %q, not %q", ErrorFormatSimple, ErrorFormatProblem, c.API.ErrorFormat)
+	check(slices.Contains([]string{ActivationAlwaysActive, ActivationRequireVerification, ActivationRequireApproval}, c.App.ActivationMode),
+		"ACCOUNT_ACTIVATION_MODE %q is not supported", c.App.ActivationMode)
+	// New accounts could never be activated without a way to send the link
+	check(development || c.App.ActivationMode != ActivationRequireVerification || c.Mail.SMTPHost != "",
+		"MAIL_SMTP_HOST is required for ACCOUNT_ACTIVATION_MODE=%s when ENVIRONMENT=%s", ActivationRequireVerification, c.App.Environment)
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// redacted replaces secret values when the config is logged
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the config with secrets masked, safe for logging
+func (c Config) Redacted() Config {
+	if c.DB.Password != "" {
+		c.DB.Password = redacted
+	}
+	if c.Auth.JWTSecret != "" {
+		c.Auth.JWTSecret = redacted
+	}
+	if c.Auth.TOTPEncryptionKey != "" {
+		c.Auth.TOTPEncryptionKey = redacted
+	}
+	if c.Mail.Password != "" {
+		c.Mail.Password = redacted
+	}
+	if c.Health.DrainToken != "" {
+		c.Health.DrainToken = redacted
+	}
+	return c
 }
 
 func (c *DatabaseConfig) GetDSN() string {
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
+	if c.ApplicationName != "" {
+		dsn += " application_name=" + quoteDSNValue(c.ApplicationName)
+	}
+	return dsn
+}
+
+// GetMigrationURL returns the connection settings as a postgres:// URL, which
+// is the form golang-migrate expects
+func (c *DatabaseConfig) GetMigrationURL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.DBName,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// quoteDSNValue quotes a value for a key=value connection string so that
+// spaces and quotes survive parsing
+func quoteDSNValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
 }
 
 func getEnv(key string, defaultValue string) string {
@@ -109,3 +695,97 @@ func getEnv(key string, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvList reads a comma-separated list, trimming whitespace and dropping empty items
+func getEnvList(key string, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envParser reads typed environment variables, recording an error for each
+// value that doesn't parse instead of falling back to the zero value
+type envParser struct {
+	errs []error
+}
+
+func (p *envParser) fail(key, value, want string) {
+	p.errs = append(p.errs, fmt.Errorf("%s: %q is not a valid %s", key, value, want))
+}
+
+func (p *envParser) Int(key string, defaultValue string) int {
+	value := getEnv(key, defaultValue)
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		p.fail(key, value, "integer")
+	}
+	return n
+}
+
+func (p *envParser) Int64(key string, defaultValue string) int64 {
+	value := getEnv(key, defaultValue)
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		p.fail(key, value, "integer")
+	}
+	return n
+}
+
+func (p *envParser) Float(key string, defaultValue string) float64 {
+	value := getEnv(key, defaultValue)
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.fail(key, value, "number")
+	}
+	return f
+}
+
+func (p *envParser) Bool(key string, defaultValue string) bool {
+	value := getEnv(key, defaultValue)
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(key, value, "boolean")
+	}
+	return b
+}
+
+// DurationMap reads comma-separated key=value pairs where each value is a
+// number of the given unit
+func (p *envParser) DurationMap(key string, defaultValue string, unit time.Duration) map[string]time.Duration {
+	values := make(map[string]time.Duration)
+	for _, item := range getEnvList(key, defaultValue) {
+		name, rawValue, ok := strings.Cut(item, "=")
+		if !ok {
+			p.fail(key, item, "name=value pair")
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(rawValue))
+		if err != nil {
+			p.fail(key, item, "name=value pair")
+			continue
+		}
+		values[strings.TrimSpace(name)] = time.Duration(value) * unit
+	}
+	return values
+}
+
+// RateLimits reads comma-separated pattern=rps:burst pairs with positive values
+func (p *envParser) RateLimits(key string, defaultValue string) map[string]RateLimitRule {
+	rules := make(map[string]RateLimitRule)
+	for _, item := range getEnvList(key, defaultValue) {
+		name, rawValue, ok := strings.Cut(item, "=")
+		rawRPS, rawBurst, okRule := strings.Cut(strings.TrimSpace(rawValue), ":")
+		rps, errRPS := strconv.ParseFloat(rawRPS, 64)
+		burst, errBurst := strconv.Atoi(rawBurst)
+		if !ok || !okRule || errRPS != nil || errBurst != nil || rps <= 0 || burst <= 0 {
+			p.fail(key, item, "pattern=rps:burst rule")
+			continue
+		}
+		rules[strings.TrimSpace(name)] = RateLimitRule{RPS: rps, Burst: burst}
+	}
+	return rules
+}
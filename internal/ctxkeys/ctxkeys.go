@@ -0,0 +1,115 @@
+// Package ctxkeys owns the request-scoped values stored in a context.Context.
+// Keys are unexported so values can only be set and read through the typed
+// helpers below, which rules out collisions between packages.
+package ctxkeys
+
+import (
+	"context"
+	"maps"
+	"sync/atomic"
+	"time"
+)
+
+type key int
+
+const (
+	userIDKey key = iota
+	roleKey
+	requestTimeKey
+	queryTagsKey
+	queryCounterKey
+	requestIDKey
+)
+
+// WithUserID returns a context carrying the authenticated user's ID
+func WithUserID(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserID returns the authenticated user's ID, if any
+func UserID(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	userID, ok := ctx.Value(userIDKey).(uint)
+	return userID, ok
+}
+
+// WithRole returns a context carrying the authenticated user's role
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// Role returns the authenticated user's role, if any
+func Role(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	role, ok := ctx.Value(roleKey).(string)
+	return role, ok && role != ""
+}
+
+// WithRequestTime returns a context carrying a single timestamp to be shared by
+// every row written while handling one request
+func WithRequestTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, requestTimeKey, t)
+}
+
+// RequestTime returns the timestamp set by WithRequestTime, if any
+func RequestTime(ctx context.Context) (time.Time, bool) {
+	if ctx == nil {
+		return time.Time{}, false
+	}
+	t, ok := ctx.Value(requestTimeKey).(time.Time)
+	return t, ok
+}
+
+// WithQueryTags returns a context whose database queries are annotated with
+// the given tags, merged over any tags already present
+func WithQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := maps.Clone(QueryTags(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(tags))
+	}
+	maps.Copy(merged, tags)
+	return context.WithValue(ctx, queryTagsKey, merged)
+}
+
+// QueryTags returns the tags set by WithQueryTags
+func QueryTags(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	tags, _ := ctx.Value(queryTagsKey).(map[string]string)
+	return tags
+}
+
+// WithQueryCounter returns a context whose database queries are counted in c
+func WithQueryCounter(ctx context.Context, c *atomic.Int64) context.Context {
+	return context.WithValue(ctx, queryCounterKey, c)
+}
+
+// QueryCounter returns the counter set by WithQueryCounter, or nil if queries
+// aren't counted
+func QueryCounter(ctx context.Context) *atomic.Int64 {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(queryCounterKey).(*atomic.Int64)
+	return c
+}
+
+// WithRequestID returns a context carrying the ID that correlates the log lines
+// of one request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID set by WithRequestID, if any
+func RequestID(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok && id != ""
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	"go_postgres/internal/config"
@@ -16,13 +18,23 @@ import (
 
 type PostgresDB struct {
 	DB *gorm.DB
+
+	// stopStats ends the pool statistics logger, which closes statsDone on exit;
+	// both are nil when the logger isn't running
+	stopStats chan struct{}
+	statsDone chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type GormLogAdapter struct {
 	Logger *zap.Logger
 }
 
-func NewPostgresDB(cfg *config.DatabaseConfig, zapLogger *zap.Logger) (*PostgresDB, error) {
+// NewPostgresDB connects to the database, retrying with exponential backoff
+// while it isn't reachable yet. ctx bounds the whole connection sequence.
+func NewPostgresDB(ctx context.Context, cfg *config.DatabaseConfig, zapLogger *zap.Logger) (*PostgresDB, error) {
 	gormLogger := logger.New(
 		NewGormLogAdapter(zapLogger),
 		logger.Config{
@@ -33,36 +45,131 @@ func NewPostgresDB(cfg *config.DatabaseConfig, zapLogger *zap.Logger) (*Postgres
 		},
 	)
 
-	db, err := gorm.Open(postgres.Open(cfg.GetDSN()), &gorm.Config{
-		Logger: gormLogger,
-		NamingStrategy: schema.NamingStrategy{
-			TablePrefix:   "app_",
-			SingularTable: false,
-		},
-		PrepareStmt: true,
-	})
+	open := func(ctx context.Context) (*gorm.DB, error) {
+		db, err := gorm.Open(postgres.Open(cfg.GetDSN()), &gorm.Config{
+			Logger: gormLogger,
+			NamingStrategy: schema.NamingStrategy{
+				TablePrefix:   "app_",
+				SingularTable: false,
+			},
+			// Tagged queries carry a per-request comment, so every statement would be
+			// unique and the prepared statement cache would grow without bound
+			PrepareStmt: !cfg.QueryTagging,
+			// autoCreateTime/autoUpdateTime values shouldn't depend on the host's
+			// zone, and are cut to Postgres' microseconds so a saved model
+			// matches the row read back, as ETags rely on
+			NowFunc: func() time.Time {
+				return time.Now().UTC().Truncate(time.Microsecond)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := sqlDB.PingContext(pingCtx); err != nil {
+			_ = sqlDB.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+
+	db, err := connectWithRetry(ctx, cfg.ConnectRetries, cfg.ConnectBackoff, zapLogger, open)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := registerTimingCallbacks(db); err != nil {
+		return nil, fmt.Errorf("failed to register timing callbacks: %w", err)
+	}
+
+	if cfg.QueryTimeout > 0 {
+		if err := registerQueryTimeout(db, cfg.QueryTimeout); err != nil {
+			return nil, fmt.Errorf("failed to register query timeout: %w", err)
+		}
+	}
+
+	if cfg.QueryCountThreshold > 0 {
+		if err := registerQueryCounting(db); err != nil {
+			return nil, fmt.Errorf("failed to register query counting: %w", err)
+		}
+	}
+
+	if cfg.AuditSoftDelete {
+		if err := registerSoftDeleteAudit(db, zapLogger); err != nil {
+			return nil, fmt.Errorf("failed to register soft delete audit: %w", err)
+		}
+	}
+
+	if cfg.QueryTagging {
+		if err := registerQueryTagging(db); err != nil {
+			return nil, fmt.Errorf("failed to register query tagging: %w", err)
+		}
+	}
+
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
 
+	checkPoolSizing(cfg, zapLogger, numCPU())
+
 	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
-	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetMaxIdleConns(maxIdleConns(cfg))
 	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLife)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := sqlDB.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	p := &PostgresDB{DB: db}
+	if cfg.PoolStatsInterval > 0 {
+		p.stopStats = make(chan struct{})
+		p.statsDone = make(chan struct{})
+		go p.logPoolStats(cfg.PoolStatsInterval, zapLogger)
 	}
 
 	zapLogger.Info("successfully connected to the database")
-	return &PostgresDB{DB: db}, nil
+	return p, nil
+}
+
+// Ping checks that the database is reachable
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	sqlDB, err := p.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Stats returns the connection pool statistics
+func (p *PostgresDB) Stats() sql.DBStats {
+	sqlDB, err := p.DB.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+	return sqlDB.Stats()
+}
+
+// Close closes the connection pool, waiting for queries in progress to finish.
+// It is safe to call more than once; later calls return the first result.
+func (p *PostgresDB) Close() error {
+	p.closeOnce.Do(func() {
+		if p.stopStats != nil {
+			close(p.stopStats)
+			<-p.statsDone
+		}
+
+		sqlDB, err := p.DB.DB()
+		if err != nil {
+			p.closeErr = err
+			return
+		}
+		p.closeErr = sqlDB.Close()
+	})
+	return p.closeErr
 }
 
 func NewGormLogAdapter(zapLogger *zap.Logger) *GormLogAdapter {
@@ -2,28 +2,142 @@ package migrations
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 
-	"github.com/golang-migrate/migrate"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
+// MinSchemaVersion is the oldest schema version this build of the code can
+// serve against. Bump it whenever code starts depending on a new migration.
+const MinSchemaVersion uint = 13
+
+var (
+	ErrSchemaBehind = errors.New("database schema is behind the expected version")
+	// ErrDirtyMigration means a migration failed part way through. Nothing more
+	// can be applied until an operator repairs the schema by hand and records
+	// the version it is actually at with ForceVersion.
+	ErrDirtyMigration = errors.New("database schema is dirty")
+)
+
+//go:embed sql/*.sql
 var migrationsFS embed.FS
 
 func RunMigrations(dsn string) error {
-	d, err := iofs.New(migrationsFS, "sql")
+	m, err := newMigrate(dsn)
 	if err != nil {
-		return fmt.Errorf("failed to create migration source: %w", &err)
+		return err
 	}
+	defer m.Close()
 
-	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
-	if err != nil {
-		return fmt.Errorf("failed to create migration instance: %w", &err)
+	// Up would fail too, but with an error that doesn't say how to recover
+	if version, dirty, err := m.Version(); err == nil && dirty {
+		return dirtyError(version)
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		var dirty migrate.ErrDirty
+		if errors.As(err, &dirty) {
+			return dirtyError(uint(dirty.Version))
+		}
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	return nil
 }
+
+// ForceVersion records version as the schema's current, clean version without
+// running any migration. It is the repair step after fixing a dirty schema by
+// hand; -1 marks the database as never migrated.
+func ForceVersion(dsn string, version int) error {
+	if version < -1 {
+		return fmt.Errorf("invalid migration version %d", version)
+	}
+
+	m, err := newMigrate(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("failed to force migration version %d: %w", version, err)
+	}
+
+	return nil
+}
+
+// RollbackMigrations reverts the last steps applied migrations, newest first
+func RollbackMigrations(dsn string, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("rollback steps must be at least 1, got %d", steps)
+	}
+
+	m, err := newMigrate(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Steps(-steps); err != nil {
+		return fmt.Errorf("failed to roll back %d migration(s): %w", steps, err)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the database's current migration version and whether
+// the last migration left it dirty. An unmigrated database reports version 0.
+func SchemaVersion(databaseURL string) (uint, bool, error) {
+	m, err := newMigrate(databaseURL)
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// CheckVersion returns ErrSchemaBehind if the database hasn't been migrated
+// up to MinSchemaVersion or a migration was left half-applied
+func CheckVersion(databaseURL string) error {
+	version, dirty, err := SchemaVersion(databaseURL)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return dirtyError(version)
+	}
+	if version < MinSchemaVersion {
+		return fmt.Errorf("%w: database is at version %d, code requires at least %d", ErrSchemaBehind, version, MinSchemaVersion)
+	}
+	return nil
+}
+
+// dirtyError tells the operator which migration failed and how to recover
+func dirtyError(version uint) error {
+	return fmt.Errorf("%w: migration %d did not complete; repair the schema by hand, then force the version it is at, e.g. -migrate force -version %d",
+		ErrDirtyMigration, version, int(version)-1)
+}
+
+func newMigrate(databaseURL string) (*migrate.Migrate, error) {
+	d, err := iofs.New(migrationsFS, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration source: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", d, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration instance: %w", err)
+	}
+	return m, nil
+}
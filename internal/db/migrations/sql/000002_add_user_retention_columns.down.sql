@@ -0,0 +1,3 @@
+ALTER TABLE app_users
+    DROP COLUMN IF EXISTS anonymized_at,
+    DROP COLUMN IF EXISTS last_login_at;
@@ -0,0 +1,3 @@
+ALTER TABLE app_users
+    ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER SEQUENCE IF EXISTS app_users_id_seq AS INTEGER;
+ALTER TABLE app_users ALTER COLUMN id TYPE INTEGER;
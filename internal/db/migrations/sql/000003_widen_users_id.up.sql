@@ -0,0 +1,3 @@
+-- Generated IDs (e.g. Snowflake) need the full 64-bit range
+ALTER TABLE app_users ALTER COLUMN id TYPE BIGINT;
+ALTER SEQUENCE IF EXISTS app_users_id_seq AS BIGINT;
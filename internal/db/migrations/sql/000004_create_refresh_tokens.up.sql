@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS app_refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES app_users(id) ON DELETE CASCADE,
+    -- Every token rotated from the same login shares a family, so reuse of any
+    -- one of them can revoke the whole chain
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON app_refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON app_refresh_tokens(family_id);
@@ -0,0 +1 @@
+ALTER TABLE app_users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,3 @@
+ALTER TABLE app_users
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'
+        CHECK (role IN ('admin', 'user', 'readonly'));
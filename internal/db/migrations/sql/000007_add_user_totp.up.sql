@@ -0,0 +1,5 @@
+ALTER TABLE app_users
+    ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    -- Last accepted time step, so a code can't be replayed within its window
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS app_users_email_key;
+
+ALTER TABLE app_users ADD CONSTRAINT app_users_email_key UNIQUE (email);
+CREATE INDEX IF NOT EXISTS idx_users_email ON app_users(email);
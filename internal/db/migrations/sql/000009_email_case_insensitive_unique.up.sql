@@ -0,0 +1,6 @@
+-- Emails are compared case-insensitively. The new index keeps the old
+-- constraint's name so unique violations are still reported on "email".
+ALTER TABLE app_users DROP CONSTRAINT IF EXISTS app_users_email_key;
+DROP INDEX IF EXISTS idx_users_email;
+
+CREATE UNIQUE INDEX app_users_email_key ON app_users (LOWER(email));
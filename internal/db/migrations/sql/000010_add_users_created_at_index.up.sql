@@ -0,0 +1,2 @@
+-- Serves both the offset and the cursor ordering of the user list
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON app_users (created_at DESC, id DESC);
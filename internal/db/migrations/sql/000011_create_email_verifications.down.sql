@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS app_email_verifications;
+
+ALTER TABLE app_users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,12 @@
+-- No foreign keys: entries must outlive the users they mention, including purged ones
+CREATE TABLE IF NOT EXISTS app_audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id BIGINT,
+    target_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    changes JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_logs_target_id ON app_audit_logs(target_id, id DESC);
+CREATE INDEX idx_audit_logs_actor_id ON app_audit_logs(actor_id, id DESC);
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"runtime"
+	"time"
+
+	"go_postgres/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// checkPoolSizing logs advisory warnings for connection pool settings that are
+// commonly wrong. It never changes the configuration.
+func checkPoolSizing(cfg *config.DatabaseConfig, logger *zap.Logger, cpus int) {
+	suggestedOpen := cpus * 4
+	suggestedIdle := max(suggestedOpen/4, 2)
+
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		logger.Warn("DB_MAX_IDLE_CONNS exceeds DB_MAX_OPEN_CONNS; clamping it to DB_MAX_OPEN_CONNS",
+			zap.Int("max_idle_conns", cfg.MaxIdleConns),
+			zap.Int("max_open_conns", cfg.MaxOpenConns),
+			zap.Int("suggested_max_idle_conns", min(suggestedIdle, cfg.MaxOpenConns)),
+		)
+	} else if cfg.MaxIdleConns > 2 && cfg.MaxIdleConns == cfg.MaxOpenConns {
+		// Every connection ever opened stays open, holding a backend per replica
+		logger.Warn("DB_MAX_IDLE_CONNS equals DB_MAX_OPEN_CONNS; the pool never shrinks after a burst",
+			zap.Int("max_idle_conns", cfg.MaxIdleConns),
+			zap.Int("suggested_max_idle_conns", min(suggestedIdle, cfg.MaxOpenConns)),
+		)
+	}
+
+	if cfg.MaxOpenConns <= 0 {
+		logger.Warn("DB_MAX_OPEN_CONNS is unlimited; a traffic spike can exhaust the server's max_connections",
+			zap.Int("suggested_max_open_conns", suggestedOpen),
+		)
+	} else if cfg.MaxOpenConns > suggestedOpen*4 {
+		logger.Warn("DB_MAX_OPEN_CONNS is high for this machine's CPU count; more connections than cores mostly adds contention",
+			zap.Int("max_open_conns", cfg.MaxOpenConns),
+			zap.Int("cpus", cpus),
+			zap.Int("suggested_max_open_conns", suggestedOpen),
+		)
+	}
+}
+
+// maxIdleConns caps the idle pool at the open limit. database/sql would do the
+// same silently; doing it here keeps the effective value explicit.
+func maxIdleConns(cfg *config.DatabaseConfig) int {
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		return cfg.MaxOpenConns
+	}
+	return cfg.MaxIdleConns
+}
+
+// numCPU is the CPU count pool sizing advice is based on
+func numCPU() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// logPoolStats logs the pool statistics every interval until stopStats is
+// closed. Wait figures are for the interval just ended, so saturation shows
+// up as it happens rather than being averaged into the process lifetime.
+func (p *PostgresDB) logPoolStats(interval time.Duration, logger *zap.Logger) {
+	defer close(p.statsDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev sql.DBStats
+	for {
+		select {
+		case <-p.stopStats:
+			return
+		case <-ticker.C:
+			stats := p.Stats()
+			logger.Info("Database pool stats",
+				zap.Int("max_open", stats.MaxOpenConnections),
+				zap.Int("open", stats.OpenConnections),
+				zap.Int("in_use", stats.InUse),
+				zap.Int("idle", stats.Idle),
+				zap.Int64("wait_count", stats.WaitCount-prev.WaitCount),
+				zap.Duration("wait_duration", stats.WaitDuration-prev.WaitDuration),
+				zap.Int64("max_idle_closed", stats.MaxIdleClosed-prev.MaxIdleClosed),
+				zap.Int64("max_lifetime_closed", stats.MaxLifetimeClosed-prev.MaxLifetimeClosed),
+			)
+			prev = stats
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"errors"
+
+	"go_postgres/internal/ctxkeys"
+
+	"gorm.io/gorm"
+)
+
+// registerQueryCounting increments the request's query counter after every
+// statement, so middleware can spot requests issuing suspiciously many queries
+func registerQueryCounting(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().After("gorm:create").Register("querycount:create", countQuery),
+		callbacks.Query().After("gorm:query").Register("querycount:query", countQuery),
+		callbacks.Update().After("gorm:update").Register("querycount:update", countQuery),
+		callbacks.Delete().After("gorm:delete").Register("querycount:delete", countQuery),
+		callbacks.Row().After("gorm:row").Register("querycount:row", countQuery),
+		callbacks.Raw().After("gorm:raw").Register("querycount:raw", countQuery),
+	)
+}
+
+func countQuery(tx *gorm.DB) {
+	if counter := ctxkeys.QueryCounter(tx.Statement.Context); counter != nil {
+		counter.Add(1)
+	}
+}
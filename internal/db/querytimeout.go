@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const queryTimeoutCancelKey = "query_timeout:cancel"
+
+// registerQueryTimeout bounds every statement by timeout, on top of whatever
+// deadline the caller's context already has. Hooking statement execution
+// covers every repository, inside transactions too, without threading a
+// cancel func through each method.
+//
+// Row statements are left alone: their rows are read after the callbacks have
+// run, so cancelling in an after callback would cut the read short.
+func registerQueryTimeout(db *gorm.DB, timeout time.Duration) error {
+	start := func(tx *gorm.DB) {
+		ctx, cancel := context.WithTimeout(tx.Statement.Context, timeout)
+		tx.Statement.Context = ctx
+		tx.InstanceSet(queryTimeoutCancelKey, cancel)
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("query_timeout:before_create", start),
+		callbacks.Create().After("*").Register("query_timeout:after_create", stopQueryTimeout),
+		callbacks.Query().Before("*").Register("query_timeout:before_query", start),
+		callbacks.Query().After("*").Register("query_timeout:after_query", stopQueryTimeout),
+		callbacks.Update().Before("*").Register("query_timeout:before_update", start),
+		callbacks.Update().After("*").Register("query_timeout:after_update", stopQueryTimeout),
+		callbacks.Delete().Before("*").Register("query_timeout:before_delete", start),
+		callbacks.Delete().After("*").Register("query_timeout:after_delete", stopQueryTimeout),
+		callbacks.Raw().Before("*").Register("query_timeout:before_raw", start),
+		callbacks.Raw().After("*").Register("query_timeout:after_raw", stopQueryTimeout),
+	)
+}
+
+// stopQueryTimeout releases the timer once the statement and everything that
+// follows it, such as preloads and after hooks, has finished
+func stopQueryTimeout(tx *gorm.DB) {
+	if cancel, ok := tx.InstanceGet(queryTimeoutCancelKey); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// maxConnectBackoff caps the delay between connection attempts
+const maxConnectBackoff = 30 * time.Second
+
+// connectWithRetry calls open until it succeeds, retrying up to retries times
+// with exponential backoff starting at backoff. It gives up early when ctx is
+// done and returns the last connection error.
+func connectWithRetry(ctx context.Context, retries int, backoff time.Duration, logger *zap.Logger, open func(context.Context) (*gorm.DB, error)) (*gorm.DB, error) {
+	delay := backoff
+	for attempt := 1; ; attempt++ {
+		db, err := open(ctx)
+		if err == nil {
+			return db, nil
+		}
+
+		if attempt > retries {
+			return nil, err
+		}
+
+		logger.Warn("Database not reachable, retrying",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", retries+1),
+			zap.Duration("retry_in", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxConnectBackoff)
+	}
+}
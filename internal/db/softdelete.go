@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// softDeleteTables are the tables whose rows are soft deleted
+var softDeleteTables = []string{"app_users"}
+
+// registerSoftDeleteAudit warns about reads from soft-deleted tables that
+// don't filter on deleted_at and weren't explicitly Unscoped, which usually
+// means a raw query or join is leaking deleted rows. It inspects the final
+// SQL, so it's meant for development only.
+func registerSoftDeleteAudit(db *gorm.DB, logger *zap.Logger) error {
+	audit := func(tx *gorm.DB) {
+		auditSoftDelete(tx, logger)
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Query().After("gorm:query").Register("softdelete:audit_query", audit),
+		callbacks.Row().After("gorm:row").Register("softdelete:audit_row", audit),
+		callbacks.Raw().After("gorm:raw").Register("softdelete:audit_raw", audit),
+	)
+}
+
+func auditSoftDelete(tx *gorm.DB, logger *zap.Logger) {
+	if tx.Statement.Unscoped {
+		return
+	}
+
+	sql := strings.ToLower(tx.Statement.SQL.String())
+	if !strings.HasPrefix(strings.TrimSpace(sql), "select") || strings.Contains(sql, "deleted_at") {
+		return
+	}
+
+	for _, table := range softDeleteTables {
+		if strings.Contains(sql, table) {
+			logger.Warn("Query reads a soft-deleted table without filtering deleted_at; use Unscoped() if this is intended",
+				zap.String("table", table),
+				zap.String("sql", tx.Statement.SQL.String()),
+			)
+			return
+		}
+	}
+}
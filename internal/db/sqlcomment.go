@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go_postgres/internal/ctxkeys"
+
+	"gorm.io/gorm"
+)
+
+const queryTagsConnPoolKey = "query_tags:conn_pool"
+
+// registerQueryTagging appends a sqlcommenter-style comment built from the
+// context's query tags to every statement, e.g.
+// SELECT ... /*request_id='abc',route='GET%20%2Fapi%2Fusers'*/
+// so pg_stat_statements and slow query logs can be tied back to a request.
+func registerQueryTagging(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("query_tags:before_create", tagStatement),
+		callbacks.Create().After("gorm:create").Register("query_tags:after_create", untagStatement),
+		callbacks.Query().Before("gorm:query").Register("query_tags:before_query", tagStatement),
+		callbacks.Query().After("gorm:query").Register("query_tags:after_query", untagStatement),
+		callbacks.Update().Before("gorm:update").Register("query_tags:before_update", tagStatement),
+		callbacks.Update().After("gorm:update").Register("query_tags:after_update", untagStatement),
+		callbacks.Delete().Before("gorm:delete").Register("query_tags:before_delete", tagStatement),
+		callbacks.Delete().After("gorm:delete").Register("query_tags:after_delete", untagStatement),
+		callbacks.Row().Before("gorm:row").Register("query_tags:before_row", tagStatement),
+		callbacks.Row().After("gorm:row").Register("query_tags:after_row", untagStatement),
+		callbacks.Raw().Before("gorm:raw").Register("query_tags:before_raw", tagStatement),
+		callbacks.Raw().After("gorm:raw").Register("query_tags:after_raw", untagStatement),
+	)
+}
+
+// tagStatement swaps the statement's connection for one that appends the comment
+func tagStatement(tx *gorm.DB) {
+	tags := ctxkeys.QueryTags(tx.Statement.Context)
+	if len(tags) == 0 {
+		return
+	}
+
+	tx.InstanceSet(queryTagsConnPoolKey, tx.Statement.ConnPool)
+	tx.Statement.ConnPool = &commentingConnPool{
+		ConnPool: tx.Statement.ConnPool,
+		comment:  formatSQLComment(tags),
+	}
+}
+
+// untagStatement restores the original connection; GORM needs the real
+// transaction back to commit or roll it back
+func untagStatement(tx *gorm.DB) {
+	if connPool, ok := tx.InstanceGet(queryTagsConnPoolKey); ok {
+		tx.Statement.ConnPool = connPool.(gorm.ConnPool)
+	}
+}
+
+// formatSQLComment renders tags per the sqlcommenter spec: sorted keys and
+// URL-encoded values, which also keeps "*/" out of the comment
+func formatSQLComment(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, url.QueryEscape(key)+"='"+url.PathEscape(tags[key])+"'")
+	}
+	return " /*" + strings.Join(pairs, ",") + "*/"
+}
+
+type commentingConnPool struct {
+	gorm.ConnPool
+	comment string
+}
+
+func (p *commentingConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.ConnPool.PrepareContext(ctx, query+p.comment)
+}
+
+func (p *commentingConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.ConnPool.ExecContext(ctx, query+p.comment, args...)
+}
+
+func (p *commentingConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.ConnPool.QueryContext(ctx, query+p.comment, args...)
+}
+
+func (p *commentingConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.ConnPool.QueryRowContext(ctx, query+p.comment, args...)
+}
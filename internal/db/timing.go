@@ -0,0 +1,44 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"go_postgres/internal/timing"
+
+	"gorm.io/gorm"
+)
+
+const timingStartKey = "timing:start"
+
+// registerTimingCallbacks records the time spent in each statement under "db"
+// in the request's timing breakdown
+func registerTimingCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("timing:before_create", startTiming),
+		callbacks.Create().After("gorm:create").Register("timing:after_create", stopTiming),
+		callbacks.Query().Before("gorm:query").Register("timing:before_query", startTiming),
+		callbacks.Query().After("gorm:query").Register("timing:after_query", stopTiming),
+		callbacks.Update().Before("gorm:update").Register("timing:before_update", startTiming),
+		callbacks.Update().After("gorm:update").Register("timing:after_update", stopTiming),
+		callbacks.Delete().Before("gorm:delete").Register("timing:before_delete", startTiming),
+		callbacks.Delete().After("gorm:delete").Register("timing:after_delete", stopTiming),
+		callbacks.Row().Before("gorm:row").Register("timing:before_row", startTiming),
+		callbacks.Row().After("gorm:row").Register("timing:after_row", stopTiming),
+		callbacks.Raw().Before("gorm:raw").Register("timing:before_raw", startTiming),
+		callbacks.Raw().After("gorm:raw").Register("timing:after_raw", stopTiming),
+	)
+}
+
+func startTiming(tx *gorm.DB) {
+	if timing.FromContext(tx.Statement.Context) != nil {
+		tx.InstanceSet(timingStartKey, time.Now())
+	}
+}
+
+func stopTiming(tx *gorm.DB) {
+	if start, ok := tx.InstanceGet(timingStartKey); ok {
+		timing.Track(tx.Statement.Context, "db", time.Since(start.(time.Time)))
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go_postgres/internal/service"
+	"go_postgres/internal/validation"
+
+	"go.uber.org/zap"
+)
+
+// maxBulkUsers bounds a bulk create request; every user costs a bcrypt hash
+const maxBulkUsers = 100
+
+const (
+	bulkStatusCreated = "created"
+	bulkStatusFailed  = "failed"
+)
+
+// BulkCreateItem reports the outcome of one entry of a bulk create request.
+// Index is the entry's position in the request array.
+type BulkCreateItem struct {
+	Index  int                   `json:"index"`
+	Status string                `json:"status"`
+	User   *service.UserResponse `json:"user,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// BulkCreateResponse is returned by CreateUsers
+type BulkCreateResponse struct {
+	Created int              `json:"created"`
+	Failed  int              `json:"failed"`
+	Results []BulkCreateItem `json:"results"`
+}
+
+// CreateUsers answers POST /api/users/bulk with an array of users to create.
+// Entries are created or rejected individually and the response lists the
+// outcome of each.
+func (h *UserHandler) CreateUsers(w http.ResponseWriter, r *http.Request) {
+	var reqs []service.CreateUserRequest
+	if err := h.decodeJSON(w, r, &reqs); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if len(reqs) == 0 {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "At least one user is required")
+		return
+	}
+	if len(reqs) > maxBulkUsers {
+		h.respondWithError(w, r, http.StatusRequestEntityTooLarge, CodeBatchTooLarge, fmt.Sprintf("At most %d users can be created at once", maxBulkUsers))
+		return
+	}
+
+	items := make([]BulkCreateItem, len(reqs))
+	var valid []service.CreateUserRequest
+	var indexes []int
+	for i, req := range reqs {
+		items[i].Index = i
+		if errs := validation.Validate(req); len(errs) > 0 {
+			items[i].Status = bulkStatusFailed
+			items[i].Error = formatValidationErrors(errs)
+			continue
+		}
+		valid = append(valid, req)
+		indexes = append(indexes, i)
+	}
+
+	if len(valid) > 0 {
+		results, err := h.userService.CreateUsers(r.Context(), valid)
+		if err != nil {
+			h.logger.Error("failed to create users", zap.Error(err))
+			h.respondWithServerError(w, r, err, "internal server error")
+			return
+		}
+		for j, result := range results {
+			item := &items[indexes[j]]
+			if result.Err != nil {
+				item.Status = bulkStatusFailed
+				item.Error = h.bulkErrorMessage(result.Err)
+				continue
+			}
+			item.Status = bulkStatusCreated
+			item.User = result.User
+		}
+	}
+
+	resp := BulkCreateResponse{Results: items}
+	for _, item := range items {
+		if item.Status == bulkStatusCreated {
+			resp.Created++
+		} else {
+			resp.Failed++
+		}
+	}
+	h.respondWithJSON(w, http.StatusOK, resp)
+}
+
+// bulkErrorMessage is the client-facing description of why one entry of a
+// bulk create failed, matching what CreateUser would have answered
+func (h *UserHandler) bulkErrorMessage(err error) string {
+	var weak *service.WeakPasswordError
+	switch {
+	case errors.Is(err, service.ErrEmailTaken):
+		return "email is already registered"
+	case errors.Is(err, service.ErrUsernameTaken):
+		return "username is already taken"
+	case errors.Is(err, service.ErrUserAlreadyExists):
+		return "user already exists"
+	case errors.As(err, &weak):
+		return "password: " + strings.Join(weak.Violations, "; ")
+	case errors.Is(err, service.ErrWeakPassword):
+		return "password: does not meet the password policy"
+	default:
+		h.logger.Error("failed to create user in bulk", zap.Error(err))
+		return "internal server error"
+	}
+}
+
+// formatValidationErrors flattens errs into one line, ordered by field
+func formatValidationErrors(errs validation.Errors) string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field + ": " + errs[field]
+	}
+	return strings.Join(parts, "; ")
+}
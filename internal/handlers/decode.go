@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var errJSONTooDeep = errors.New("JSON nesting exceeds the maximum depth")
+
+// decodeError is a request body decodeJSON refused, carrying the status and
+// client-facing message to answer with
+type decodeError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *decodeError) Error() string {
+	return e.message
+}
+
+func badBody(code, format string, args ...any) *decodeError {
+	return &decodeError{status: http.StatusBadRequest, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// decodeJSON decodes the request body into v. The body must be a single JSON
+// value within the configured size and nesting limits, using only fields v
+// knows about. Failures are *decodeError; see respondWithDecodeError.
+func (h *UserHandler) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.cfg.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &decodeError{
+				status:  http.StatusRequestEntityTooLarge,
+				code:    CodePayloadTooLarge,
+				message: fmt.Sprintf("Request body must not be larger than %d bytes", tooLarge.Limit),
+			}
+		}
+		return badBody(CodeInvalidRequest, "Failed to read request body")
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return badBody(CodeInvalidRequest, "Request body must not be empty")
+	}
+
+	if h.cfg.MaxJSONDepth > 0 {
+		if err := checkJSONDepth(body, h.cfg.MaxJSONDepth); err != nil {
+			if errors.Is(err, errJSONTooDeep) {
+				return badBody(CodeInvalidRequest, "Request body is nested more than %d levels deep", h.cfg.MaxJSONDepth)
+			}
+			return malformedJSON(err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return badBody(CodeInvalidRequest, "Request body has the wrong type for field %q", typeErr.Field)
+		case errors.As(err, &typeErr):
+			return badBody(CodeInvalidRequest, "Request body must be a JSON %s", expectedKind(v))
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			// encoding/json has no typed error for this case
+			return badBody(CodeUnknownField, "Request body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		default:
+			return malformedJSON(err)
+		}
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return badBody(CodeInvalidRequest, "Request body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// malformedJSON describes a syntax error, with its offset when known
+func malformedJSON(err error) *decodeError {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return badBody(CodeMalformedJSON, "Request body contains malformed JSON at position %d", syntaxErr.Offset)
+	}
+	return badBody(CodeMalformedJSON, "Request body contains malformed JSON")
+}
+
+// expectedKind names the JSON type v decodes from, for error messages
+func expectedKind(v interface{}) string {
+	if reflect.Indirect(reflect.ValueOf(v)).Kind() == reflect.Slice {
+		return "array"
+	}
+	return "object"
+}
+
+// respondWithDecodeError answers a request whose body decodeJSON refused
+func (h *UserHandler) respondWithDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		h.respondWithError(w, r, decodeErr.status, decodeErr.code, decodeErr.message)
+		return
+	}
+	h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+}
+
+// checkJSONDepth walks the tokens of data and fails as soon as objects and
+// arrays are nested more than maxDepth levels deep
+func checkJSONDepth(data []byte, maxDepth int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return errJSONTooDeep
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
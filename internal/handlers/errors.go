@@ -0,0 +1,63 @@
+package handlers
+
+import "go_postgres/internal/validation"
+
+// Error codes identify what went wrong in an error response, independent of
+// the human-readable message. Clients can branch on them; once published a
+// code must not change meaning.
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeMalformedJSON      = "MALFORMED_JSON"
+	CodeUnknownField       = "UNKNOWN_FIELD"
+	CodePayloadTooLarge    = "PAYLOAD_TOO_LARGE"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeNotAcceptable      = "NOT_ACCEPTABLE"
+	CodeInvalidUserID      = "INVALID_USER_ID"
+	CodeInvalidPage        = "INVALID_PAGINATION"
+	CodeInvalidCursor      = "INVALID_CURSOR"
+	CodePageOutOfRange     = "PAGE_OUT_OF_RANGE"
+	CodeBatchTooLarge      = "BATCH_TOO_LARGE"
+	CodePreconditionFailed = "PRECONDITION_FAILED"
+
+	CodeUnauthorized    = "UNAUTHORIZED"
+	CodeForbidden       = "FORBIDDEN"
+	CodeInternal        = "INTERNAL_ERROR" // Also written by the recover middleware
+	CodeUnavailable     = "SERVICE_UNAVAILABLE"
+	CodeTooManyRequests = "TOO_MANY_REQUESTS" // Also written by the rate limit middleware
+	CodeRequestTimeout  = "REQUEST_TIMEOUT"   // Written by the timeout middleware
+
+	// Codes for the service's sentinel errors, e.g. CodeUserNotFound for
+	// service.ErrUserNotFound
+	CodeUserNotFound            = "USER_NOT_FOUND"
+	CodeUserAlreadyExists       = "USER_ALREADY_EXISTS"
+	CodeEmailTaken              = "EMAIL_TAKEN"
+	CodeUsernameTaken           = "USERNAME_TAKEN"
+	CodeInvalidCredentials      = "INVALID_CREDENTIALS"
+	CodeAccountInactive         = "ACCOUNT_INACTIVE"
+	CodeAccountLocked           = "ACCOUNT_LOCKED"
+	CodeEmailNotVerified        = "EMAIL_NOT_VERIFIED"
+	CodePasswordReused          = "PASSWORD_REUSED"
+	CodeInvalidRefreshToken     = "INVALID_REFRESH_TOKEN"
+	CodeInvalidVerification     = "INVALID_VERIFICATION_TOKEN"
+	CodeVerificationExpired     = "VERIFICATION_TOKEN_EXPIRED"
+	CodeInvalidResetToken       = "INVALID_RESET_TOKEN"
+	CodeResetTokenExpired       = "RESET_TOKEN_EXPIRED"
+	CodeInvalidChallenge        = "INVALID_TWO_FACTOR_CHALLENGE"
+	CodeInvalidTwoFactorCode    = "INVALID_TWO_FACTOR_CODE"
+	CodeTwoFactorNotEnrolled    = "TWO_FACTOR_NOT_ENROLLED"
+	CodeTwoFactorAlreadyEnabled = "TWO_FACTOR_ALREADY_ENABLED"
+	CodeTwoFactorUnavailable    = "TWO_FACTOR_UNAVAILABLE"
+)
+
+// ErrorBody is the payload of error responses in the simple format
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error by its stable code and a message for humans
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the invalid fields of a VALIDATION_FAILED error
+	Fields validation.Errors `json:"fields,omitempty"`
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_postgres/internal/service"
+)
+
+// userETag is a weak validator for user: any change to the user bumps
+// UpdatedAt. It is weak because the JSON and XML representations share it.
+func userETag(user *service.UserResponse) string {
+	return `W/"` + strconv.FormatUint(uint64(user.ID), 10) + "-" + strconv.FormatInt(user.UpdatedAt.UnixNano(), 36) + `"`
+}
+
+// etagMatches reports whether header, an If-None-Match or If-Match list,
+// contains etag or "*". Tags are compared weakly, ignoring the W/ prefix.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets the ETag header for user and answers 304 if the
+// client's If-None-Match already has it. It returns true when the response
+// has been written.
+func checkNotModified(w http.ResponseWriter, r *http.Request, user *service.UserResponse) bool {
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// ifMatchVersions turns an If-Match header for user id into the UpdatedAt
+// values it accepts, for service.UpdateUserRequest.IfUpdatedAt. It returns nil
+// when the header is absent or "*", and an empty slice when no tag can match.
+func ifMatchVersions(header string, id uint) []time.Time {
+	if header == "" {
+		return nil
+	}
+
+	versions := []time.Time{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil
+		}
+
+		tag := strings.Trim(strings.TrimPrefix(candidate, "W/"), `"`)
+		idPart, nanosPart, ok := strings.Cut(tag, "-")
+		if !ok || idPart != strconv.FormatUint(uint64(id), 10) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(nanosPart, 36, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, time.Unix(0, nanos))
+	}
+	return versions
+}
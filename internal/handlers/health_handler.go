@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/db"
+	"go_postgres/internal/version"
+
+	"go.uber.org/zap"
+)
+
+// HealthResponse is the optional detail returned by the liveness check
+type HealthResponse struct {
+	Status        string  `json:"status"`
+	Version       string  `json:"version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Goroutines    int     `json:"goroutines"`
+}
+
+// ReadinessResponse is the body of /readyz
+type ReadinessResponse struct {
+	Status   string         `json:"status"`
+	Database DatabaseHealth `json:"database"`
+}
+
+// DatabaseHealth reports reachability and connection pool usage
+type DatabaseHealth struct {
+	Status          string `json:"status"`
+	OpenConnections int    `json:"open_connections"`
+	InUse           int    `json:"in_use"`
+	Idle            int    `json:"idle"`
+}
+
+type HealthHandler struct {
+	db      *db.PostgresDB
+	logger  *zap.Logger
+	clock   clock.Clock
+	started time.Time
+	cfg     *config.HealthConfig
+	// draining makes readiness fail so traffic moves away before shutdown
+	draining atomic.Bool
+}
+
+func NewHealthHandler(db *db.PostgresDB, logger *zap.Logger, clk clock.Clock, cfg *config.HealthConfig) *HealthHandler {
+	return &HealthHandler{
+		db:      db,
+		logger:  logger,
+		clock:   clk,
+		started: clk.Now(),
+		cfg:     cfg,
+	}
+}
+
+// Liveness answers GET and HEAD /healthz. It never touches dependencies so it
+// stays fast; the body is empty unless detail is enabled in config or asked
+// for with ?detail=true.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	detail := h.cfg.Detailed
+	if value := r.URL.Query().Get("detail"); value != "" {
+		detail, _ = strconv.ParseBool(value)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !detail {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, err := json.Marshal(HealthResponse{
+		Status:        "ok",
+		Version:       version.Version,
+		UptimeSeconds: h.clock.Now().Sub(h.started).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+	})
+	if err != nil {
+		h.logger.Error("Failed to encode health response", zap.Error(err))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// Readiness answers GET and HEAD /readyz. It returns 503 once draining has
+// started, so the load balancer stops sending new requests, or when the
+// database doesn't answer a ping in time. The body reports pool usage.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{Status: "ok", Database: DatabaseHealth{Status: "ok"}}
+	code := http.StatusOK
+
+	if h.draining.Load() {
+		response.Status = "draining"
+		code = http.StatusServiceUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.ReadyTimeout)
+	defer cancel()
+	if err := h.db.Ping(ctx); err != nil {
+		h.logger.Warn("Readiness check failed to ping the database", zap.Error(err))
+		response.Status = "unavailable"
+		response.Database.Status = "unreachable"
+		code = http.StatusServiceUnavailable
+	}
+
+	stats := h.db.Stats()
+	response.Database.OpenConnections = stats.OpenConnections
+	response.Database.InUse = stats.InUse
+	response.Database.Idle = stats.Idle
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		h.logger.Error("Failed to encode readiness response", zap.Error(err))
+		w.WriteHeader(code)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// Drain answers POST /api/admin/drain. It only flips readiness; in-flight and
+// new requests keep being served until the process is told to shut down.
+func (h *HealthHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.cfg.DrainToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.DrainToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.StartDraining() {
+		h.logger.Info("Draining: readiness now reports not ready")
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// StartDraining makes readiness fail from now on and reports whether this call
+// started the drain
+func (h *HealthHandler) StartDraining() bool {
+	return h.draining.CompareAndSwap(false, true)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/models"
+)
+
+// GetMe answers GET /api/users/me with the authenticated user
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := h.currentUserID(w, r); ok {
+		h.getUser(w, r, userID)
+	}
+}
+
+// UpdateMe answers PUT /api/users/me, updating the authenticated user
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := h.currentUserID(w, r); ok {
+		h.updateUser(w, r, userID)
+	}
+}
+
+// DeleteMe answers DELETE /api/users/me by soft-deleting the authenticated
+// user's account. Purging stays an admin action.
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := h.currentUserID(w, r); ok {
+		h.deleteUser(w, r, userID, false)
+	}
+}
+
+// currentUserID returns the authenticated user's ID, answering 401 if there
+// is none
+func (h *UserHandler) currentUserID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID, ok := ctxkeys.UserID(r.Context())
+	if !ok {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return 0, false
+	}
+	return userID, true
+}
+
+// authorizeOwnerOrAdmin lets the request act on user id only if it is the
+// authenticated user's own account or the caller is an admin, answering 403
+// otherwise
+func (h *UserHandler) authorizeOwnerOrAdmin(w http.ResponseWriter, r *http.Request, id uint) bool {
+	userID, ok := h.currentUserID(w, r)
+	if !ok {
+		return false
+	}
+	if role, _ := ctxkeys.Role(r.Context()); userID != id && role != models.RoleAdmin {
+		h.respondWithError(w, r, http.StatusForbidden, CodeForbidden, "You can only modify your own account")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// TwoFactorChallengeResponse is returned by AuthenticateUser instead of tokens
+// when the account has two-factor authentication enabled
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// EnrollTwoFactor answers POST /api/users/me/2fa/enroll with a new secret and
+// otpauth:// URL. Two-factor stays off until the first code is confirmed.
+func (h *UserHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ctxkeys.UserID(r.Context())
+	if !ok {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	enrollment, err := h.twoFactor.Enroll(r.Context(), userID)
+	if err != nil {
+		h.respondWithTwoFactorError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.respondWithJSON(w, http.StatusOK, enrollment)
+}
+
+// ConfirmTwoFactor answers POST /api/users/me/2fa/verify, enabling two-factor
+// once the user proves their authenticator produces valid codes
+func (h *UserHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.withTwoFactorCode(w, r, h.twoFactor.Confirm)
+}
+
+// DisableTwoFactor answers POST /api/users/me/2fa/disable; a current code is
+// required so a hijacked session alone can't turn it off
+func (h *UserHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.withTwoFactorCode(w, r, h.twoFactor.Disable)
+}
+
+// RegenerateBackupCodes answers POST /api/users/me/2fa/backup-codes with a new
+// set of backup codes, invalidating the old ones
+func (h *UserHandler) RegenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ctxkeys.UserID(r.Context())
+	if !ok {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if req.Code == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateBackupCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		h.respondWithTwoFactorError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.respondWithJSON(w, http.StatusOK, map[string][]string{"backup_codes": codes})
+}
+
+// CompleteTwoFactorLogin answers POST /api/auth/2fa, exchanging the challenge
+// token from the password step and a code for access and refresh tokens
+func (h *UserHandler) CompleteTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	userID, err := h.tokens.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidChallenge, "Invalid or expired challenge")
+		return
+	}
+
+	if err := h.twoFactor.Verify(r.Context(), userID, req.Code); err != nil {
+		h.respondWithTwoFactorError(w, r, err)
+		return
+	}
+
+	user, err := h.userService.GetUser(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidChallenge, "Invalid or expired challenge")
+		} else {
+			h.logger.Error("Failed to load user for two-factor login", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	h.respondWithLogin(w, r, user)
+}
+
+// respondWithTwoFactorChallenge asks the client for a code before issuing tokens
+func (h *UserHandler) respondWithTwoFactorChallenge(w http.ResponseWriter, r *http.Request, user *service.UserResponse) {
+	challenge, err := h.tokens.GenerateChallengeToken(user.ID, h.authCfg.TwoFactorChallengeTTL)
+	if err != nil {
+		h.logger.Error("Failed to generate two-factor challenge", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int64(h.authCfg.TwoFactorChallengeTTL.Seconds()),
+	})
+}
+
+// withTwoFactorCode decodes a code for the authenticated user and passes it to
+// action, answering 204 on success
+func (h *UserHandler) withTwoFactorCode(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, userID uint, code string) error) {
+	userID, ok := ctxkeys.UserID(r.Context())
+	if !ok {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if req.Code == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	if err := action(r.Context(), userID, req.Code); err != nil {
+		h.respondWithTwoFactorError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) respondWithTwoFactorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFactorCode):
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidTwoFactorCode, "Invalid two-factor code")
+	case errors.Is(err, service.ErrAccountLocked):
+		h.respondWithError(w, r, http.StatusTooManyRequests, CodeAccountLocked, "Too many failed attempts; try again later")
+	case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+		h.respondWithError(w, r, http.StatusConflict, CodeTwoFactorAlreadyEnabled, "Two-factor authentication is already enabled")
+	case errors.Is(err, service.ErrTwoFactorNotEnrolled):
+		h.respondWithError(w, r, http.StatusConflict, CodeTwoFactorNotEnrolled, "Two-factor authentication is not enrolled")
+	case errors.Is(err, service.ErrUserNotFound):
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+	case errors.Is(err, service.ErrTwoFactorUnavailable):
+		h.respondWithError(w, r, http.StatusNotImplemented, CodeTwoFactorUnavailable, "Two-factor authentication is not available")
+	default:
+		h.logger.Error("Two-factor operation failed", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+	}
+}
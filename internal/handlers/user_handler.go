@@ -1,43 +1,139 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
+	"go_postgres/internal/auth"
+	"go_postgres/internal/config"
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/models"
+	"go_postgres/internal/pagination"
 	"go_postgres/internal/service"
+	"go_postgres/internal/validation"
 
 	"go.uber.org/zap"
 )
 
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeXML     = "application/xml"
+	contentTypeProblem = "application/problem+json"
+)
+
+// ProblemDetails is an RFC 7807 error response
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable error code, as in simple error responses
+	Code string `json:"code,omitempty"`
+	// Errors lists field-level validation failures
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// PaginatedResponse is the payload of every offset-paginated list endpoint.
+// In XML the root element is named by XMLName and each item by its own type.
+type PaginatedResponse[T any] struct {
+	XMLName xml.Name `json:"-"`
+	Data    []T      `json:"data"`
+	pagination.Metadata
+}
+
+// newPaginatedResponse wraps one page of items; an empty page encodes as [] rather than null
+func newPaginatedResponse[T any](root string, data []T, metadata pagination.Metadata) PaginatedResponse[T] {
+	if data == nil {
+		data = []T{}
+	}
+	return PaginatedResponse[T]{
+		XMLName:  xml.Name{Local: root},
+		Data:     data,
+		Metadata: metadata,
+	}
+}
+
+// CursorPaginatedResponse is the payload of list endpoints in cursor mode.
+// NextCursor is omitted on the last page.
+type CursorPaginatedResponse[T any] struct {
+	XMLName    xml.Name `json:"-"`
+	Data       []T      `json:"data"`
+	NextCursor string   `json:"next_cursor,omitempty" xml:"next_cursor,attr,omitempty"`
+}
+
+// TokenResponse carries a fresh access token and the refresh token to renew it
+type TokenResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LoginResponse is returned by AuthenticateUser
+type LoginResponse struct {
+	User *service.UserResponse `json:"user"`
+	TokenResponse
+}
+
 type UserHandler struct {
-	userService service.UserService
-	logger      *zap.Logger
+	userService  service.UserService
+	tokenService service.TokenService
+	twoFactor    service.TwoFactorService
+	verification service.VerificationService
+	tokens       *auth.TokenManager
+	logger       *zap.Logger
+	cfg          *config.APIConfig
+	authCfg      *config.AuthConfig
 }
 
-func NewUserHandler(userService service.UserService, logger *zap.Logger) *UserHandler {
+func NewUserHandler(userService service.UserService, tokenService service.TokenService, twoFactor service.TwoFactorService, verification service.VerificationService, tokens *auth.TokenManager, logger *zap.Logger, cfg *config.APIConfig, authCfg *config.AuthConfig) *UserHandler {
 	return &UserHandler{
-		userService: userService,
-		logger:      logger,
+		userService:  userService,
+		tokenService: tokenService,
+		twoFactor:    twoFactor,
+		verification: verification,
+		tokens:       tokens,
+		logger:       logger,
+		cfg:          cfg,
+		authCfg:      authCfg,
 	}
 }
 
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req service.CreateUserRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+
+	if errs := validation.Validate(req); len(errs) > 0 {
+		h.respondWithValidationErrors(w, r, errs)
 		return
 	}
 
 	user, err := h.userService.CreateUser(r.Context(), req)
 	if err != nil {
-		if errors.Is(err, service.ErrUserAlreadyExists) {
-			h.respondWithError(w, http.StatusConflict, "user already exists")
+		if errors.Is(err, service.ErrEmailTaken) {
+			h.respondWithError(w, r, http.StatusConflict, CodeEmailTaken, "email is already registered")
+		} else if errors.Is(err, service.ErrUsernameTaken) {
+			h.respondWithError(w, r, http.StatusConflict, CodeUsernameTaken, "username is already taken")
+		} else if errors.Is(err, service.ErrUserAlreadyExists) {
+			h.respondWithError(w, r, http.StatusConflict, CodeUserAlreadyExists, "user already exists")
+		} else if errors.Is(err, service.ErrWeakPassword) {
+			h.respondWithWeakPassword(w, r, err)
 		} else {
 			h.logger.Error("failed to create user", zap.Error(err))
-			h.respondWithError(w, http.StatusInternalServerError, "internal server error")
+			h.respondWithServerError(w, r, err, "internal server error")
 		}
 		return
 	}
@@ -48,116 +144,307 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from URL path
 	idStr := r.PathValue("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
+	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	h.getUser(w, r, uint(id))
+}
+
+func (h *UserHandler) getUser(w http.ResponseWriter, r *http.Request, id uint) {
+	contentType, ok := negotiateContentType(r)
+	if !ok {
+		h.respondWithError(w, r, http.StatusNotAcceptable, CodeNotAcceptable, "Not acceptable")
 		return
 	}
 
 	// Get user
-	user, err := h.userService.GetUser(r.Context(), uint(id))
+	user, err := h.userService.GetUser(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, service.ErrUserNotFound) {
-			h.respondWithError(w, http.StatusNotFound, "User not found")
+			h.respondWithError(w, r, http.StatusNotFound, CodeUserNotFound, "User not found")
 		} else {
 			h.logger.Error("Failed to get user", zap.Error(err))
-			h.respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			h.respondWithServerError(w, r, err, "Internal server error")
 		}
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusOK, user)
+	if checkNotModified(w, r, user) {
+		return
+	}
+	h.respondWithContentType(w, contentType, http.StatusOK, user)
 }
 
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination parameters
+	contentType, ok := negotiateContentType(r)
+	if !ok {
+		h.respondWithError(w, r, http.StatusNotAcceptable, CodeNotAcceptable, "Not acceptable")
+		return
+	}
+
+	// Cursor mode is opted into with ?cursor= or ?limit=; offset mode stays the default
+	if query := r.URL.Query(); query.Has("cursor") || query.Has("limit") {
+		h.listUsersByCursor(w, r, contentType)
+		return
+	}
+
+	paginator, ok := h.parsePaginator(w, r)
+	if !ok {
+		return
+	}
+
+	// Get users
+	users, total, err := h.userService.ListUsers(r.Context(), paginator)
+	if err != nil {
+		h.logger.Error("Failed to list users", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+	if !h.checkPageInRange(w, r, paginator, total) {
+		return
+	}
+
+	// Create response with pagination info
+	response := newPaginatedResponse("users", users, paginator.Metadata(total))
+
+	w.Header().Set("Link", paginator.LinkHeader(r.URL, total))
+
+	h.respondWithContentType(w, contentType, http.StatusOK, response)
+}
+
+// ListDeletedUsers answers GET /api/users/deleted with a page of soft-deleted
+// users, so admins can review deletions and find users to restore
+func (h *UserHandler) ListDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	contentType, ok := negotiateContentType(r)
+	if !ok {
+		h.respondWithError(w, r, http.StatusNotAcceptable, CodeNotAcceptable, "Not acceptable")
+		return
+	}
+
+	paginator, ok := h.parsePaginator(w, r)
+	if !ok {
+		return
+	}
+
+	users, total, err := h.userService.ListDeletedUsers(r.Context(), paginator)
+	if err != nil {
+		h.logger.Error("Failed to list deleted users", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+	if !h.checkPageInRange(w, r, paginator, total) {
+		return
+	}
+
+	response := newPaginatedResponse("deleted_users", users, paginator.Metadata(total))
+
+	w.Header().Set("Link", paginator.LinkHeader(r.URL, total))
+
+	h.respondWithContentType(w, contentType, http.StatusOK, response)
+}
+
+// parsePaginator reads ?page and ?page_size for offset pagination. On invalid
+// input it writes the error response and returns false.
+func (h *UserHandler) parsePaginator(w http.ResponseWriter, r *http.Request) (pagination.Paginator, bool) {
 	pageStr := r.URL.Query().Get("page")
 	pageSizeStr := r.URL.Query().Get("page_size")
 
 	page := 1
 	if pageStr != "" {
+		// Atoi rejects values that don't fit in an int, so huge inputs land here too
 		pageVal, err := strconv.Atoi(pageStr)
-		if err == nil && pageVal > 0 {
-			page = pageVal
+		if err != nil || pageVal < 1 {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidPage, "Invalid page")
+			return pagination.Paginator{}, false
 		}
+		page = pageVal
 	}
 
-	pageSize := 10
+	pageSize := pagination.DefaultPageSize
 	if pageSizeStr != "" {
 		pageSizeVal, err := strconv.Atoi(pageSizeStr)
-		if err == nil && pageSizeVal > 0 && pageSizeVal <= 100 {
-			pageSize = pageSizeVal
+		if err != nil || pageSizeVal < 1 || (h.cfg.MaxPageSize > 0 && pageSizeVal > h.cfg.MaxPageSize) {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidPage, "Invalid page_size")
+			return pagination.Paginator{}, false
 		}
+		pageSize = pageSizeVal
 	}
 
-	// Get users
-	users, total, err := h.userService.ListUsers(r.Context(), page, pageSize)
+	// Deep offsets force the database to scan and discard every skipped row.
+	// Compared by division so a huge page number can't overflow the offset.
+	if h.cfg.MaxOffset > 0 && page-1 > h.cfg.MaxOffset/pageSize {
+		h.respondWithError(w, r, http.StatusBadRequest, CodePageOutOfRange, "Page is too deep for offset pagination; use cursor pagination instead")
+		return pagination.Paginator{}, false
+	}
+
+	paginator, err := pagination.New(page, pageSize)
+	if err != nil {
+		h.respondWithError(w, r, http.StatusBadRequest, CodePageOutOfRange, "Page is out of range")
+		return pagination.Paginator{}, false
+	}
+
+	return paginator, true
+}
+
+// checkPageInRange refuses a page past the end of total rows in strict mode.
+// Otherwise such a page is served empty, with metadata giving the real
+// total_pages so clients can find their way back.
+func (h *UserHandler) checkPageInRange(w http.ResponseWriter, r *http.Request, paginator pagination.Paginator, total int64) bool {
+	if h.cfg.StrictPagination && paginator.PastEnd(total) {
+		h.respondWithError(w, r, http.StatusBadRequest, CodePageOutOfRange, fmt.Sprintf("Page %d is past the last page (%d)", paginator.Page, paginator.TotalPages(total)))
+		return false
+	}
+	return true
+}
+
+// listUsersByCursor serves ListUsers with keyset pagination, which stays fast
+// and consistent on large tables that change between requests
+func (h *UserHandler) listUsersByCursor(w http.ResponseWriter, r *http.Request, contentType string) {
+	query := r.URL.Query()
+
+	limit := pagination.DefaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limitVal, err := strconv.Atoi(limitStr)
+		if err != nil || limitVal < 1 || (h.cfg.MaxPageSize > 0 && limitVal > h.cfg.MaxPageSize) {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidPage, "Invalid limit")
+			return
+		}
+		limit = limitVal
+	}
+
+	var after *pagination.Cursor
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := pagination.DecodeCursor(cursorStr)
+		if err != nil {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidCursor, "Invalid cursor")
+			return
+		}
+		after = &cursor
+	}
+
+	users, next, err := h.userService.ListUsersAfter(r.Context(), after, limit)
 	if err != nil {
 		h.logger.Error("Failed to list users", zap.Error(err))
-		h.respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		h.respondWithServerError(w, r, err, "Internal server error")
 		return
 	}
 
-	// Create response with pagination info
-	response := map[string]interface{}{
-		"users":       users,
-		"total":       total,
-		"page":        page,
-		"page_size":   pageSize,
-		"total_pages": (total + int64(pageSize) - 1) / int64(pageSize),
+	response := CursorPaginatedResponse[*service.UserResponse]{
+		XMLName: xml.Name{Local: "users"},
+		Data:    users,
+	}
+	if next != nil {
+		response.NextCursor = next.Encode()
+
+		query.Set("cursor", response.NextCursor)
+		query.Set("limit", strconv.Itoa(limit))
+		link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		w.Header().Set("Link", "<"+link.String()+`>; rel="next"`)
 	}
 
-	h.respondWithJSON(w, http.StatusOK, response)
+	h.respondWithContentType(w, contentType, http.StatusOK, response)
 }
 
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from URL path
 	idStr := r.PathValue("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
+	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidUserID, "Invalid user ID")
 		return
 	}
 
+	if !h.authorizeOwnerOrAdmin(w, r, uint(id)) {
+		return
+	}
+
+	h.updateUser(w, r, uint(id))
+}
+
+func (h *UserHandler) updateUser(w http.ResponseWriter, r *http.Request, id uint) {
 	// Parse request body
 	var req service.UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
 		return
 	}
 
+	if errs := validation.Validate(req); len(errs) > 0 {
+		h.respondWithValidationErrors(w, r, errs)
+		return
+	}
+
+	// A stale If-Match means the client would overwrite changes it hasn't seen
+	req.IfUpdatedAt = ifMatchVersions(r.Header.Get("If-Match"), id)
+
 	// Update user
-	user, err := h.userService.UpdateUser(r.Context(), uint(id), req)
+	user, err := h.userService.UpdateUser(r.Context(), id, req)
 	if err != nil {
 		if errors.Is(err, service.ErrUserNotFound) {
-			h.respondWithError(w, http.StatusNotFound, "User not found")
+			h.respondWithError(w, r, http.StatusNotFound, CodeUserNotFound, "User not found")
+		} else if errors.Is(err, service.ErrPreconditionFailed) {
+			h.respondWithError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "User was modified since it was read")
+		} else if errors.Is(err, service.ErrPasswordReused) {
+			h.respondWithError(w, r, http.StatusUnprocessableEntity, CodePasswordReused, "Password was used recently; choose a different one")
+		} else if errors.Is(err, service.ErrWeakPassword) {
+			h.respondWithWeakPassword(w, r, err)
 		} else {
 			h.logger.Error("Failed to update user", zap.Error(err))
-			h.respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			h.respondWithServerError(w, r, err, "Internal server error")
 		}
 		return
 	}
 
+	w.Header().Set("ETag", userETag(user))
 	h.respondWithJSON(w, http.StatusOK, user)
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from URL path
 	idStr := r.PathValue("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
+	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	// ?purge=true erases the row for good instead of soft-deleting it
+	purge := false
+	if raw := r.URL.Query().Get("purge"); raw != "" {
+		purge, err = strconv.ParseBool(raw)
+		if err != nil {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid purge parameter")
+			return
+		}
+	}
+
+	if !h.authorizeOwnerOrAdmin(w, r, uint(id)) {
+		return
+	}
+	if role, _ := ctxkeys.Role(r.Context()); purge && role != models.RoleAdmin {
+		h.respondWithError(w, r, http.StatusForbidden, CodeForbidden, "Only admins can purge users")
 		return
 	}
 
-	// Delete user
-	err = h.userService.DeleteUser(r.Context(), uint(id))
+	h.deleteUser(w, r, uint(id), purge)
+}
+
+func (h *UserHandler) deleteUser(w http.ResponseWriter, r *http.Request, id uint, purge bool) {
+	var err error
+	if purge {
+		err = h.userService.PurgeUser(r.Context(), id)
+	} else {
+		err = h.userService.DeleteUser(r.Context(), id)
+	}
 	if err != nil {
 		if errors.Is(err, service.ErrUserNotFound) {
-			h.respondWithError(w, http.StatusNotFound, "User not found")
+			h.respondWithError(w, r, http.StatusNotFound, CodeUserNotFound, "User not found")
 		} else {
 			h.logger.Error("Failed to delete user", zap.Error(err))
-			h.respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			h.respondWithServerError(w, r, err, "Internal server error")
 		}
 		return
 	}
@@ -166,50 +453,446 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// VerifyEmail answers GET /api/auth/verify?token=..., the link emailed at
+// signup, activating the account
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidVerification, "Missing token")
+		return
+	}
+
+	if err := h.verification.VerifyEmail(r.Context(), token); err != nil {
+		if errors.Is(err, service.ErrVerificationTokenExpired) {
+			h.respondWithError(w, r, http.StatusGone, CodeVerificationExpired, "Verification link has expired")
+		} else if errors.Is(err, service.ErrInvalidVerificationToken) {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidVerification, "Invalid verification link")
+		} else {
+			h.logger.Error("Failed to verify email", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "Email address verified"})
+}
+
+// ForgotPassword answers POST /api/auth/forgot-password. The response is the
+// same whether or not the email is registered, so it can't be used to find
+// accounts.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email" validate:"required,email,max=100"`
+	}
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if errs := validation.Validate(req); len(errs) > 0 {
+		h.respondWithValidationErrors(w, r, errs)
+		return
+	}
+
+	if err := h.userService.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		h.logger.Error("Failed to start password reset", zap.Error(err))
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "If the address is registered, a password reset link has been sent",
+	})
+}
+
+// ResetPassword answers POST /api/auth/reset-password with the token from the
+// reset email and the new password
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,max=72"`
+	}
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if errs := validation.Validate(req); len(errs) > 0 {
+		h.respondWithValidationErrors(w, r, errs)
+		return
+	}
+
+	if err := h.userService.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		if errors.Is(err, service.ErrResetTokenExpired) {
+			h.respondWithError(w, r, http.StatusGone, CodeResetTokenExpired, "Password reset link has expired")
+		} else if errors.Is(err, service.ErrInvalidResetToken) {
+			h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidResetToken, "Invalid password reset link")
+		} else if errors.Is(err, service.ErrWeakPassword) {
+			h.respondWithWeakPassword(w, r, err)
+		} else if errors.Is(err, service.ErrPasswordReused) {
+			h.respondWithError(w, r, http.StatusUnprocessableEntity, CodePasswordReused, "Password was used recently; choose a different one")
+		} else {
+			h.logger.Error("Failed to reset password", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreUser undoes a soft delete of the user in the path
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	user, err := h.userService.RestoreUser(r.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			h.respondWithError(w, r, http.StatusNotFound, CodeUserNotFound, "User not found")
+		} else if errors.Is(err, service.ErrEmailTaken) {
+			h.respondWithError(w, r, http.StatusConflict, CodeEmailTaken, "email is already registered")
+		} else if errors.Is(err, service.ErrUsernameTaken) {
+			h.respondWithError(w, r, http.StatusConflict, CodeUsernameTaken, "username is already taken")
+		} else if errors.Is(err, service.ErrUserAlreadyExists) {
+			h.respondWithError(w, r, http.StatusConflict, CodeUserAlreadyExists, "user already exists")
+		} else {
+			h.logger.Error("Failed to restore user", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
 func (h *UserHandler) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
-	// Parse request body
+	// Parse request body; "email" is still accepted for older clients
 	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Identifier string `json:"identifier"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
 		return
 	}
 
-	// Authenticate user
-	user, err := h.userService.AuthenticateUser(r.Context(), req.Email, req.Password)
+	identifier := req.Identifier
+	if identifier == "" {
+		identifier = req.Email
+	}
+
+	// Authenticate user by username or email
+	user, err := h.userService.AuthenticateUser(r.Context(), identifier, req.Password)
 	if err != nil {
 		if errors.Is(err, service.ErrInvalidCredentials) {
-			h.respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+			h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+		} else if errors.Is(err, service.ErrEmailNotVerified) {
+			h.respondWithError(w, r, http.StatusForbidden, CodeEmailNotVerified, "Email address is not verified")
+		} else if errors.Is(err, service.ErrAccountInactive) {
+			h.respondWithError(w, r, http.StatusForbidden, CodeAccountInactive, "Account is not active")
+		} else if errors.Is(err, service.ErrAccountLocked) {
+			h.respondWithError(w, r, http.StatusTooManyRequests, CodeAccountLocked, "Too many failed attempts; try again later")
 		} else {
 			h.logger.Error("Failed to authenticate user", zap.Error(err))
-			h.respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			h.respondWithServerError(w, r, err, "Internal server error")
 		}
 		return
 	}
 
-	// For a real application, you would generate a JWT token here
-	// and return it in the response
+	if user.TwoFactorEnabled {
+		h.respondWithTwoFactorChallenge(w, r, user)
+		return
+	}
 
-	h.respondWithJSON(w, http.StatusOK, user)
+	h.respondWithLogin(w, r, user)
+}
+
+// respondWithLogin issues access and refresh tokens for a fully authenticated user
+func (h *UserHandler) respondWithLogin(w http.ResponseWriter, r *http.Request, user *service.UserResponse) {
+	refreshToken, err := h.tokenService.IssueRefreshToken(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("Failed to issue refresh token", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+
+	tokens, err := h.tokenResponse(user.ID, user.Role, refreshToken)
+	if err != nil {
+		h.logger.Error("Failed to generate access token", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, LoginResponse{
+		User:          user,
+		TokenResponse: tokens,
+	})
 }
 
-// respondWithError sends an error response
-func (h *UserHandler) respondWithError(w http.ResponseWriter, code int, message string) {
-	h.respondWithJSON(w, code, map[string]string{"error": message})
+// RefreshToken exchanges a refresh token for a new access token. The refresh
+// token is rotated on every use; presenting an already-used one revokes every
+// token descended from the same login.
+func (h *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	userID, refreshToken, err := h.tokenService.RotateRefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidRefreshToken, "Invalid refresh token")
+		} else {
+			h.logger.Error("Failed to rotate refresh token", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	// Load the user again so role changes and deletions take effect on refresh
+	user, err := h.userService.GetUser(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidRefreshToken, "Invalid refresh token")
+		} else {
+			h.logger.Error("Failed to load user for token refresh", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	tokens, err := h.tokenResponse(user.ID, user.Role, refreshToken)
+	if err != nil {
+		h.logger.Error("Failed to generate access token", zap.Error(err))
+		h.respondWithServerError(w, r, err, "Internal server error")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// VerifyPassword re-confirms the authenticated user's password before a
+// sensitive action, answering 204 when it matches and 401 when it doesn't
+func (h *UserHandler) VerifyPassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ctxkeys.UserID(r.Context())
+	if !ok {
+		h.respondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		h.respondWithDecodeError(w, r, err)
+		return
+	}
+	if req.Password == "" {
+		h.respondWithError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	err := h.userService.VerifyPassword(r.Context(), userID, req.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) || errors.Is(err, service.ErrUserNotFound) {
+			h.respondWithError(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+		} else if errors.Is(err, service.ErrAccountLocked) {
+			h.respondWithError(w, r, http.StatusTooManyRequests, CodeAccountLocked, "Too many failed attempts; try again later")
+		} else {
+			h.logger.Error("Failed to verify password", zap.Error(err))
+			h.respondWithServerError(w, r, err, "Internal server error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tokenResponse signs a new access token for the user and pairs it with the
+// given refresh token
+func (h *UserHandler) tokenResponse(userID uint, role string, refreshToken string) (TokenResponse, error) {
+	token, err := h.tokens.GenerateToken(userID, role, h.authCfg.AccessTokenTTL)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(h.authCfg.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// respondWithError sends an error response identified by one of the Code
+// constants, using RFC 7807 problem details when configured or when the client
+// explicitly accepts application/problem+json
+func (h *UserHandler) respondWithError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if h.cfg.ErrorFormat == config.ErrorFormatProblem || acceptsProblemJSON(r) {
+		h.respondWithProblem(w, r, status, code, message)
+		return
+	}
+
+	h.respondWithJSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: message}})
+}
+
+// respondWithServerError answers a request that failed on the server side
+// with a 500, or with a 503 when the database timed out since a retry may
+// well succeed
+func (h *UserHandler) respondWithServerError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, service.ErrQueryTimeout) {
+		w.Header().Set("Retry-After", "1")
+		h.respondWithError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "Service temporarily unavailable")
+		return
+	}
+	h.respondWithError(w, r, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// respondWithValidationErrors sends a 422 listing every invalid field, as the
+// "fields" of the error or as the "errors" member of a problem details body
+func (h *UserHandler) respondWithValidationErrors(w http.ResponseWriter, r *http.Request, errs validation.Errors) {
+	status := http.StatusUnprocessableEntity
+	message := "Request validation failed"
+	if h.cfg.ErrorFormat == config.ErrorFormatProblem || acceptsProblemJSON(r) {
+		problem := ProblemDetails{
+			Type:     h.cfg.ProblemTypeBaseURI + errorCode(status),
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   message,
+			Instance: r.URL.Path,
+			Code:     CodeValidationFailed,
+			Errors:   errs,
+		}
+		h.writeEncoded(w, contentTypeProblem, status, func(buf *bytes.Buffer) error {
+			return json.NewEncoder(buf).Encode(problem)
+		})
+		return
+	}
+
+	h.respondWithJSON(w, status, ErrorBody{Error: ErrorDetail{Code: CodeValidationFailed, Message: message, Fields: errs}})
+}
+
+// respondWithWeakPassword reports the broken password rules as a 422 on the password field
+func (h *UserHandler) respondWithWeakPassword(w http.ResponseWriter, r *http.Request, err error) {
+	message := "does not meet the password policy"
+	var weak *service.WeakPasswordError
+	if errors.As(err, &weak) {
+		message = strings.Join(weak.Violations, "; ")
+	}
+	h.respondWithValidationErrors(w, r, validation.Errors{"password": message})
+}
+
+// respondWithProblem sends an application/problem+json error response
+func (h *UserHandler) respondWithProblem(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	problem := ProblemDetails{
+		Type:     h.cfg.ProblemTypeBaseURI + errorCode(status),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   message,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+
+	h.writeEncoded(w, contentTypeProblem, status, func(buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(problem)
+	})
+}
+
+// respondWithContentType sends the payload encoded as the negotiated content type
+func (h *UserHandler) respondWithContentType(w http.ResponseWriter, contentType string, code int, payload interface{}) {
+	w.Header().Add("Vary", "Accept")
+
+	if contentType == contentTypeXML {
+		h.respondWithXML(w, code, payload)
+		return
+	}
+
+	h.respondWithJSON(w, code, payload)
 }
 
 // respondWithJSON sends a JSON response
 func (h *UserHandler) respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	// Set content type
-	w.Header().Set("Content-Type", "application/json")
+	h.writeEncoded(w, contentTypeJSON, code, func(buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(payload)
+	})
+}
 
-	// Set status code
-	w.WriteHeader(code)
+// respondWithXML sends an XML response
+func (h *UserHandler) respondWithXML(w http.ResponseWriter, code int, payload interface{}) {
+	h.writeEncoded(w, contentTypeXML, code, func(buf *bytes.Buffer) error {
+		buf.WriteString(xml.Header)
+		return xml.NewEncoder(buf).Encode(payload)
+	})
+}
 
-	// Encode payload to JSON
-	if err := json.NewEncoder(w).Encode(payload); err != nil {
+// writeEncoded encodes the body into a buffer before writing anything, so an
+// encoding failure becomes a clean 500 instead of a success status followed by
+// a truncated body
+func (h *UserHandler) writeEncoded(w http.ResponseWriter, contentType string, code int, encode func(*bytes.Buffer) error) {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
 		h.logger.Error("Failed to encode response", zap.Error(err))
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, `{"error":{"code":"INTERNAL_ERROR","message":"Internal server error"}}`+"\n")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+
+	if _, err := buf.WriteTo(w); err != nil {
+		h.logger.Error("Failed to write response", zap.Error(err))
+	}
+}
+
+// negotiateContentType picks the response content type from the Accept header.
+// JSON is used when the header is missing or accepts anything; the second return
+// value is false when none of the accepted media types can be produced.
+func negotiateContentType(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return contentTypeJSON, true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case contentTypeJSON, "application/*", "*/*":
+			return contentTypeJSON, true
+		case contentTypeXML, "text/xml":
+			return contentTypeXML, true
+		}
+	}
+
+	return "", false
+}
+
+// acceptsProblemJSON reports whether the client explicitly asked for RFC 7807 errors
+func acceptsProblemJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), contentTypeProblem) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCode derives a machine-readable error code from the HTTP status,
+// e.g. 404 becomes "not-found"
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return strconv.Itoa(status)
 	}
+	return strings.ToLower(strings.ReplaceAll(text, " ", "-"))
 }
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Columns is the order of the fields in an import file
+var Columns = []string{"username", "email", "password_hash", "first_name", "last_name"}
+
+// Record is a single user to import. Passwords must already be bcrypt hashed;
+// hashing hundreds of thousands of passwords inline would dominate the import.
+type Record struct {
+	Username     string
+	Email        string
+	PasswordHash string
+	FirstName    string
+	LastName     string
+}
+
+// Result summarizes one Import call
+type Result struct {
+	Staged   int64
+	Inserted int64
+	// Skipped rows conflicted with an existing user or an earlier row of the batch
+	Skipped int64
+}
+
+// BulkImporter loads users with Postgres COPY into a staging table and merges
+// them into app_users in one statement, which is much faster than
+// CreateInBatches for large files. Rows bypass GORM hooks, so IDs always come
+// from the database sequence.
+type BulkImporter struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewBulkImporter(db *gorm.DB, logger *zap.Logger) *BulkImporter {
+	return &BulkImporter{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const createStagingTableSQL = `
+CREATE TEMP TABLE import_users (
+    username VARCHAR(50),
+    email VARCHAR(100),
+    password_hash VARCHAR(100),
+    first_name VARCHAR(50),
+    last_name VARCHAR(50)
+) ON COMMIT DROP`
+
+// DO NOTHING also skips rows that conflict with rows inserted earlier by the
+// same statement, so duplicates inside the file are dropped too
+const mergeStagingTableSQL = `
+INSERT INTO app_users (username, email, password_hash, first_name, last_name, is_active, created_at, updated_at)
+SELECT username, LOWER(email), password_hash, first_name, last_name, true, now(), now()
+FROM import_users
+ON CONFLICT DO NOTHING`
+
+// Import copies records into a temporary staging table and merges them into
+// app_users within a single transaction
+func (i *BulkImporter) Import(ctx context.Context, records []Record) (*Result, error) {
+	sqlDB, err := i.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	rows := make([][]any, len(records))
+	for idx, record := range records {
+		rows[idx] = []any{record.Username, record.Email, record.PasswordHash, record.FirstName, record.LastName}
+	}
+
+	result := &Result{}
+	err = conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("bulk import requires the pgx driver")
+		}
+		pgxConn := stdlibConn.Conn()
+
+		tx, err := pgxConn.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback(ctx)
+
+		if _, err := tx.Exec(ctx, createStagingTableSQL); err != nil {
+			return fmt.Errorf("failed to create staging table: %w", err)
+		}
+
+		result.Staged, err = tx.CopyFrom(ctx, pgx.Identifier{"import_users"}, Columns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return fmt.Errorf("failed to copy into staging table: %w", err)
+		}
+
+		tag, err := tx.Exec(ctx, mergeStagingTableSQL)
+		if err != nil {
+			return fmt.Errorf("failed to merge staging table: %w", err)
+		}
+		result.Inserted = tag.RowsAffected()
+		result.Skipped = result.Staged - result.Inserted
+
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		i.logger.Error("bulk import failed", zap.Error(err))
+		return nil, err
+	}
+
+	i.logger.Info("bulk import finished",
+		zap.Int64("staged", result.Staged),
+		zap.Int64("inserted", result.Inserted),
+		zap.Int64("skipped", result.Skipped),
+	)
+	return result, nil
+}
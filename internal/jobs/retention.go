@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// RetentionJob anonymizes accounts that have been inactive for longer than the
+// configured retention period
+type RetentionJob struct {
+	repo   repository.UserRepository
+	logger *zap.Logger
+	clock  clock.Clock
+	cfg    *config.RetentionConfig
+}
+
+func NewRetentionJob(repo repository.UserRepository, logger *zap.Logger, clk clock.Clock, cfg *config.RetentionConfig) *RetentionJob {
+	return &RetentionJob{
+		repo:   repo,
+		logger: logger,
+		clock:  clk,
+		cfg:    cfg,
+	}
+}
+
+// Run executes the job immediately and then on every interval until ctx is cancelled
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.RunOnce(ctx); err != nil {
+			j.logger.Error("retention job failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce anonymizes every account inactive beyond the retention period and
+// returns how many were processed. In dry-run mode nothing is written.
+func (j *RetentionJob) RunOnce(ctx context.Context) (int, error) {
+	now := j.clock.Now()
+	cutoff := now.Add(-j.cfg.Period)
+
+	processed := 0
+	var afterID uint
+	for {
+		users, err := j.repo.ListInactiveSince(ctx, cutoff, afterID, j.cfg.BatchSize)
+		if err != nil {
+			return processed, err
+		}
+
+		for _, user := range users {
+			afterID = user.ID
+
+			if j.cfg.DryRun {
+				j.logger.Info("retention dry run: would anonymize user",
+					zap.Uint("user_id", user.ID),
+					zap.Timep("last_login_at", user.LastLoginAt),
+				)
+				processed++
+				continue
+			}
+
+			user.Anonymize(now)
+			if err := j.repo.Update(ctx, user); err != nil {
+				return processed, err
+			}
+
+			// Audit entry for the irreversible scrub
+			j.logger.Info("user anonymized",
+				zap.String("action", "user.anonymize"),
+				zap.Uint("user_id", user.ID),
+				zap.Time("cutoff", cutoff),
+			)
+			processed++
+		}
+
+		if len(users) < j.cfg.BatchSize {
+			break
+		}
+	}
+
+	j.logger.Info("retention job finished",
+		zap.Int("processed", processed),
+		zap.Bool("dry_run", j.cfg.DryRun),
+	)
+	return processed, nil
+}
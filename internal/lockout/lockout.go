@@ -0,0 +1,102 @@
+// Package lockout temporarily locks accounts after repeated failed password
+// checks, whichever endpoint they come from.
+package lockout
+
+import (
+	"sync"
+	"time"
+
+	"go_postgres/internal/clock"
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracker counts consecutive failures per account in memory. Counts are per
+// process, so with several replicas an attacker gets maxFailures per replica.
+// It is safe for concurrent use.
+type Tracker struct {
+	mu          sync.Mutex
+	clock       clock.Clock
+	maxFailures int
+	duration    time.Duration
+	entries     map[uint]*entry
+}
+
+// NewTracker locks an account for duration after maxFailures consecutive
+// failures; maxFailures <= 0 disables locking
+func NewTracker(clk clock.Clock, maxFailures int, duration time.Duration) *Tracker {
+	return &Tracker{
+		clock:       clk,
+		maxFailures: maxFailures,
+		duration:    duration,
+		entries:     make(map[uint]*entry),
+	}
+}
+
+// Locked reports whether the account is locked and for how much longer
+func (t *Tracker) Locked(id uint) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[id]
+	if !ok {
+		return 0, false
+	}
+
+	now := t.clock.Now()
+	if remaining := e.lockedUntil.Sub(now); remaining > 0 {
+		return remaining, true
+	}
+	t.expire(id, e, now)
+	return 0, false
+}
+
+// Fail records a failed attempt and reports whether it locked the account
+func (t *Tracker) Fail(id uint) bool {
+	if t.maxFailures <= 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	e, ok := t.entries[id]
+	if !ok {
+		e = &entry{}
+		t.entries[id] = e
+	}
+
+	// Failures spread out over longer than a lockout don't add up
+	if now.Sub(e.lastFailure) > t.duration {
+		e.failures = 0
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures >= t.maxFailures {
+		e.failures = 0
+		e.lockedUntil = now.Add(t.duration)
+		return true
+	}
+	return false
+}
+
+// Reset clears the failures after a successful attempt
+func (t *Tracker) Reset(id uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, id)
+}
+
+// expire drops entries that no longer lock or count towards a lock, so the map
+// only holds accounts with recent failures
+func (t *Tracker) expire(id uint, e *entry, now time.Time) {
+	if now.Sub(e.lastFailure) > t.duration {
+		delete(t.entries, id)
+	}
+}
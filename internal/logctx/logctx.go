@@ -0,0 +1,20 @@
+// Package logctx adds the request-scoped fields from a context to log entries,
+// so lines logged by handlers and services can be tied to their request.
+package logctx
+
+import (
+	"context"
+
+	"go_postgres/internal/ctxkeys"
+
+	"go.uber.org/zap"
+)
+
+// Logger returns logger with the request ID from ctx attached, or logger
+// itself outside a request
+func Logger(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if id, ok := ctxkeys.RequestID(ctx); ok {
+		return logger.With(zap.String("request_id", id))
+	}
+	return logger
+}
@@ -1,53 +1,90 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-)
+	"time"
+
+	"go_postgres/internal/auth"
+	"go_postgres/internal/config"
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/logctx"
+	"go_postgres/internal/models"
+	"go_postgres/internal/timing"
 
-// Key type for context values
-type contextKey string
+	"go.uber.org/zap"
+)
 
-// Context keys
+// Headers accepted in place of a token when the development bypass is enabled
 const (
-	UserIDKey contextKey = "user_id"
+	devUserIDHeader   = "X-Dev-User-ID"
+	devUserRoleHeader = "X-Dev-User-Role"
 )
 
-// AuthMiddleware is a middleware for authentication
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the Authorization header
-		authHeader := r.Header.Get("Authorization")
+// AuthMiddleware verifies the bearer token and stores the user ID it was
+// issued for in the request context
+func AuthMiddleware(tokens *auth.TokenManager, cfg *config.AuthConfig, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
 
-		// Check if the Authorization header is present and starts with "Bearer "
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+			// LoadConfig refuses to enable the bypass outside development
+			if cfg.DevAuthBypass && r.Header.Get(devUserIDHeader) != "" {
+				userID, err := strconv.ParseUint(r.Header.Get(devUserIDHeader), 10, 0)
+				if err != nil || userID == 0 {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				role := r.Header.Get(devUserRoleHeader)
+				if role == "" {
+					role = models.RoleUser
+				}
 
-		// Extract the token
-		token := strings.TrimPrefix(authHeader, "Bearer ")
+				logctx.Logger(r.Context(), logger).Warn("INSECURE: request authenticated by development bypass header",
+					zap.Uint64("user_id", userID),
+					zap.String("role", role),
+					zap.String("path", r.URL.Path),
+				)
 
-		// In a real application, you would validate the token here
-		// For example, you would verify the JWT signature and extract claims
+				ctx := ctxkeys.WithUserID(r.Context(), uint(userID))
+				ctx = ctxkeys.WithRole(ctx, role)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 
-		// For demonstration purposes, we'll just set a dummy user ID in the context
-		// In a real application, you would extract the user ID from the token claims
-		userID := uint(1)
+			// Get the Authorization header
+			authHeader := r.Header.Get("Authorization")
 
-		// Add the user ID to the request context
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			// Check if the Authorization header is present and starts with "Bearer "
+			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
 
-		// Call the next handler with the updated context
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			// Extract and verify the token
+			token := strings.TrimPrefix(authHeader, "Bearer ")
+			claims, err := tokens.ParseToken(token)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Add the user ID and role to the request context
+			ctx := ctxkeys.WithUserID(r.Context(), claims.UserID)
+			ctx = ctxkeys.WithRole(ctx, claims.Role)
+			timing.Track(ctx, "auth", time.Since(start))
+
+			// Call the next handler with the updated context
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // GetUserID gets the user ID from the request context
 func GetUserID(r *http.Request) (uint, bool) {
-	userID, ok := r.Context().Value(UserIDKey).(uint)
-	return userID, ok
+	return ctxkeys.UserID(r.Context())
 }
 
 // RequireAuthentication is a middleware that requires authentication
@@ -64,3 +101,25 @@ func RequireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole is a middleware that only lets through users holding one of the
+// given roles. It relies on AuthMiddleware having stored the role, so it
+// composes with RequireAuthentication; a token without a role is forbidden.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := GetUserID(r); !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			role, ok := ctxkeys.Role(r.Context())
+			if !ok || !slices.Contains(roles, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
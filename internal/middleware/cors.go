@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go_postgres/internal/config"
+)
+
+// CORS is a middleware that applies the configured cross-origin policy and
+// answers preflight requests directly
+func CORS(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	allowAnyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowAnyOrigin && !slices.Contains(cfg.AllowedOrigins, origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowAnyOrigin && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods, headers := corsRoutePolicy(cfg, r.URL.Path)
+			if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// corsRoutePolicy returns the allowed methods and headers for a path, applying
+// the longest matching route override on top of the global defaults
+func corsRoutePolicy(cfg config.CORSConfig, path string) ([]string, []string) {
+	methods, headers := cfg.AllowedMethods, cfg.AllowedHeaders
+
+	longest := -1
+	for prefix, route := range cfg.Routes {
+		if !strings.HasPrefix(path, prefix) || len(prefix) <= longest {
+			continue
+		}
+		longest = len(prefix)
+		methods, headers = cfg.AllowedMethods, cfg.AllowedHeaders
+		if len(route.AllowedMethods) > 0 {
+			methods = route.AllowedMethods
+		}
+		if len(route.AllowedHeaders) > 0 {
+			headers = route.AllowedHeaders
+		}
+	}
+
+	return methods, headers
+}
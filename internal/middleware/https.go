@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"go_postgres/internal/config"
+)
+
+// EnforceHTTPS redirects plain HTTP requests to HTTPS and sets the
+// Strict-Transport-Security header on secure responses. The scheme is taken
+// from X-Forwarded-Proto since TLS is expected to terminate at a proxy.
+func EnforceHTTPS(cfg config.HTTPSConfig) func(http.Handler) http.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))
+	if cfg.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enforce {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(cfg.ExemptPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+				// 308 keeps the method and body, unlike 301
+				http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+				return
+			}
+
+			w.Header().Set("Strict-Transport-Security", hsts)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"net/http"
 	"time"
 
+	"go_postgres/internal/logctx"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type responseWriter struct {
@@ -20,8 +23,15 @@ func RequestLogger(logger *zap.Logger) func(http.Handler) http.Handler {
 			rw := newResponseWriter(w)
 			next.ServeHTTP(rw, r)
 
+			// Check first so the fields aren't built when the entry would be
+			// dropped by the level or the sampler
+			ce := logctx.Logger(r.Context(), logger).Check(zapcore.InfoLevel, "HTTP request")
+			if ce == nil {
+				return
+			}
+
 			duration := time.Since(start)
-			logger.Info("HTTP request",
+			ce.Write(
 				zap.String("method", r.Method),
 				zap.String("path", r.URL.Path),
 				zap.String("query", r.URL.RawQuery),
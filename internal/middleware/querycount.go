@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/logctx"
+
+	"go.uber.org/zap"
+)
+
+// QueryCounter counts the database queries issued while serving each request
+// and warns when a request exceeds threshold, which usually means an N+1
+// pattern such as calling GetByID in a loop. A threshold of 0 disables it.
+func QueryCounter(mux *http.ServeMux, logger *zap.Logger, threshold int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var count atomic.Int64
+			next.ServeHTTP(w, r.WithContext(ctxkeys.WithQueryCounter(r.Context(), &count)))
+
+			if queries := count.Load(); queries > int64(threshold) {
+				_, pattern := mux.Handler(r)
+				logctx.Logger(r.Context(), logger).Warn("Request issued many database queries; possible N+1",
+					zap.String("route", pattern),
+					zap.String("path", r.URL.Path),
+					zap.Int64("queries", queries),
+					zap.Int("threshold", threshold),
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go_postgres/internal/ctxkeys"
+)
+
+// QueryTags tags the request's database queries with the route pattern the
+// mux dispatches to, so SQL comments identify the endpoint without leaking IDs
+func QueryTags(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			if pattern == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := ctxkeys.WithQueryTags(r.Context(), map[string]string{"route": pattern})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
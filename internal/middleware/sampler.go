@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"go_postgres/internal/config"
+	"go_postgres/internal/logctx"
+	"go_postgres/internal/timing"
+
+	"go.uber.org/zap"
+)
+
+// SlowRequestSampler collects a timing breakdown (auth, db, ...) for every
+// request and logs it for requests slower than the threshold, plus a random
+// sample of the rest
+func SlowRequestSampler(logger *zap.Logger, cfg config.SlowRequestConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Share the breakdown with other consumers (e.g. ServerTiming) if one exists
+			ctx := r.Context()
+			breakdown := timing.FromContext(ctx)
+			if breakdown == nil {
+				breakdown = timing.NewBreakdown()
+				ctx = timing.WithBreakdown(ctx, breakdown)
+			}
+			start := time.Now()
+
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			total := time.Since(start)
+			reason := "slow"
+			if total < cfg.Threshold {
+				if rand.Float64() >= cfg.SampleRate {
+					return
+				}
+				reason = "sampled"
+			}
+
+			durations := breakdown.Durations()
+			counts := breakdown.Counts()
+			fields := []zap.Field{
+				zap.String("reason", reason),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", rw.status),
+				zap.Duration("total", total),
+			}
+
+			// Whatever isn't attributed to a component is handler/application time
+			other := total
+			for name, d := range durations {
+				fields = append(fields, zap.Duration(name, d), zap.Int(name+"_count", counts[name]))
+				other -= d
+			}
+			fields = append(fields, zap.Duration("other", other))
+
+			logctx.Logger(r.Context(), logger).Info("Request trace", fields...)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go_postgres/internal/timing"
+)
+
+// ServerTiming adds a Server-Timing header breaking the request down into the
+// components recorded in its timing breakdown (auth, db, ...) plus "app" for
+// the remaining handler time, so browsers can show it in devtools. It exposes
+// internals and should only be enabled in development.
+func ServerTiming(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			breakdown := timing.FromContext(ctx)
+			if breakdown == nil {
+				breakdown = timing.NewBreakdown()
+				ctx = timing.WithBreakdown(ctx, breakdown)
+			}
+
+			tw := &serverTimingWriter{ResponseWriter: w, breakdown: breakdown, start: time.Now()}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		})
+	}
+}
+
+// serverTimingWriter sets the header just before the status is written, which
+// is the last moment headers can change
+type serverTimingWriter struct {
+	http.ResponseWriter
+	breakdown   *timing.Breakdown
+	start       time.Time
+	wroteHeader bool
+}
+
+func (tw *serverTimingWriter) WriteHeader(code int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.Header().Set("Server-Timing", formatServerTiming(tw.breakdown, time.Since(tw.start)))
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *serverTimingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// formatServerTiming renders e.g. `auth;dur=0.41, db;dur=2.73;desc="3 calls", app;dur=1.02`
+func formatServerTiming(breakdown *timing.Breakdown, total time.Duration) string {
+	durations := breakdown.Durations()
+	counts := breakdown.Counts()
+
+	names := make([]string, 0, len(durations))
+	for name := range durations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	metrics := make([]string, 0, len(names)+1)
+	app := total
+	for _, name := range names {
+		metric := fmt.Sprintf("%s;dur=%.2f", name, milliseconds(durations[name]))
+		if counts[name] > 1 {
+			metric += fmt.Sprintf(`;desc="%d calls"`, counts[name])
+		}
+		metrics = append(metrics, metric)
+		app -= durations[name]
+	}
+	metrics = append(metrics, fmt.Sprintf("app;dur=%.2f", milliseconds(max(app, 0))))
+
+	return strings.Join(metrics, ", ")
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeout bounds how long a request may run. The budget is looked up by the
+// route pattern the mux would dispatch to (e.g. "POST /api/auth/login"),
+// falling back to defaultTimeout. Requests over budget get a 503.
+func Timeout(mux *http.ServeMux, defaultTimeout time.Duration, routes map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := defaultTimeout
+			if _, pattern := mux.Handler(r); pattern != "" {
+				if routeTimeout, ok := routes[pattern]; ok {
+					timeout = routeTimeout
+				}
+			}
+
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			http.TimeoutHandler(next, timeout, `{"error":{"code":"REQUEST_TIMEOUT","message":"request timed out"}}`).ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditUserDelete        = "user.delete"
+	AuditUserRestore       = "user.restore"
+	AuditUserPurge         = "user.purge"
+	AuditUserLogin         = "user.login"
+	AuditUserPasswordReset = "user.password_reset"
+)
+
+// AuditLog is an append-only record of an action taken on a user
+type AuditLog struct {
+	ID       uint   `gorm:"primaryKey"`
+	ActorID  *uint  `gorm:"index"` // Nil when no one was authenticated, e.g. a signup
+	TargetID uint   `gorm:"not null;index"`
+	Action   string `gorm:"size:50;not null"`
+	// Changes maps each changed field to its old and new value
+	Changes   json.RawMessage `gorm:"type:jsonb"`
+	CreatedAt time.Time       `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for the AuditLog model
+func (AuditLog) TableName() string {
+	return "app_audit_logs"
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// BackupCode is a one-time recovery code accepted in place of a TOTP code.
+// Only a hash of the code is stored.
+type BackupCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	CodeHash  string     `gorm:"size:64;not null"`
+	UsedAt    *time.Time // Set when the code is redeemed
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for the BackupCode model
+func (BackupCode) TableName() string {
+	return "app_backup_codes"
+}
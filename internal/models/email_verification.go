@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// EmailVerification is a single-use token proving the user can read mail sent
+// to their address. Only a hash of the token is stored.
+type EmailVerification struct {
+	ID         uint       `gorm:"primaryKey"`
+	UserID     uint       `gorm:"not null;index"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // Set once the token has been used
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for the EmailVerification model
+func (EmailVerification) TableName() string {
+	return "app_email_verifications"
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// PasswordHistory is a previous password hash kept to prevent reuse
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;index"`
+	PasswordHash string    `gorm:"size:100;not null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for the PasswordHistory model
+func (PasswordHistory) TableName() string {
+	return "app_password_history"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PasswordReset is a single-use token that lets a user who forgot their
+// password choose a new one. Only a hash of the token is stored.
+type PasswordReset struct {
+	ID         uint       `gorm:"primaryKey"`
+	UserID     uint       `gorm:"not null;index"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // Set once the token has been used or superseded
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for the PasswordReset model
+func (PasswordReset) TableName() string {
+	return "app_password_resets"
+}
@@ -1,23 +1,50 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/idgen"
+
 	"gorm.io/gorm"
 )
 
+// idGenerator assigns IDs in BeforeCreate; the default defers to the database sequence
+var idGenerator idgen.Generator = idgen.Sequence{}
+
+// SetIDGenerator replaces the generator used to assign IDs to new users
+func SetIDGenerator(g idgen.Generator) {
+	idGenerator = g
+}
+
+// Roles a user can hold
+const (
+	RoleAdmin    = "admin"
+	RoleUser     = "user"
+	RoleReadonly = "readonly"
+)
+
 // User represents a user in our system
 type User struct {
 	ID           uint           `gorm:"primaryKey" json:"id"`
 	Username     string         `gorm:"size:50;uniqueIndex;not null" json:"username"`
-	Email        string         `gorm:"size:100;uniqueIndex;not null" json:"email"`
+	Email        string         `gorm:"size:100;not null;uniqueIndex:app_users_email_key,expression:LOWER(email)" json:"email"`
 	PasswordHash string         `gorm:"size:100;not null" json:"-"` // Never expose in JSON
 	FirstName    string         `gorm:"size:50" json:"first_name"`
 	LastName     string         `gorm:"size:50" json:"last_name"`
 	IsActive     bool           `gorm:"default:true" json:"is_active"`
+	Role         string         `gorm:"size:20;not null;default:user" json:"role"`
 	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"` // Support for soft delete
+	LastLoginAt  *time.Time     `json:"-"`
+	AnonymizedAt *time.Time     `json:"-"`                                    // Set once personal data has been scrubbed
+	TOTPSecret   *string        `gorm:"column:totp_secret;size:255" json:"-"` // Encrypted; set from enrollment on
+	TOTPEnabled  bool           `gorm:"column:totp_enabled;not null;default:false" json:"-"`
+	TOTPLastStep int64          `gorm:"column:totp_last_step;not null;default:0" json:"-"`
+	// EmailVerifiedAt is set when the user confirms their address
+	EmailVerifiedAt *time.Time `json:"-"`
 }
 
 // TableName specifies the table name for the User model
@@ -27,8 +54,25 @@ func (User) TableName() string {
 
 // BeforeCreate is a GORM hook that runs before creating a record
 func (u *User) BeforeCreate(tx *gorm.DB) error {
-	// You can implement any pre-save logic here
-	// For example, hashing the password (although this should be done at the service level)
+	if u.ID == 0 {
+		id, err := idGenerator.NextID()
+		if err != nil {
+			return fmt.Errorf("failed to generate user id: %w", err)
+		}
+		u.ID = id
+	}
+
+	// Use the request time when one is set so every row of a batch gets the
+	// same timestamps; GORM only fills in the zero values itself
+	if now, ok := ctxkeys.RequestTime(tx.Statement.Context); ok {
+		now = now.UTC().Truncate(time.Microsecond)
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = now
+		}
+		if u.UpdatedAt.IsZero() {
+			u.UpdatedAt = now
+		}
+	}
 	return nil
 }
 
@@ -37,3 +81,18 @@ func (u *User) BeforeUpdate(tx *gorm.DB) error {
 	// You can implement any pre-update logic here
 	return nil
 }
+
+// Anonymize irreversibly scrubs personal data from the user, leaving a shell
+// row so that references to the ID stay valid
+func (u *User) Anonymize(now time.Time) {
+	u.Username = fmt.Sprintf("anonymized-%d", u.ID)
+	u.Email = fmt.Sprintf("anonymized-%d@anonymized.invalid", u.ID)
+	u.PasswordHash = "!" // Not a valid bcrypt hash, so it can never match
+	u.FirstName = ""
+	u.LastName = ""
+	u.IsActive = false
+	u.LastLoginAt = nil
+	u.TOTPSecret = nil
+	u.TOTPEnabled = false
+	u.AnonymizedAt = &now
+}
@@ -0,0 +1,105 @@
+// Package pagination holds the offset pagination math shared by list endpoints.
+package pagination
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultPageSize is used when a request doesn't ask for a page size
+const DefaultPageSize = 10
+
+// ErrOutOfRange is returned when a page's offset wouldn't fit in an int
+var ErrOutOfRange = errors.New("page out of range")
+
+// Paginator describes one page of an offset-paginated list
+type Paginator struct {
+	Page     int
+	PageSize int
+}
+
+// New returns a paginator for the 1-based page, falling back to the first page
+// and DefaultPageSize for non-positive values
+func New(page, pageSize int) (Paginator, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+
+	// Compared by division so the offset multiplication can't wrap around
+	if page-1 > math.MaxInt/pageSize {
+		return Paginator{}, ErrOutOfRange
+	}
+
+	return Paginator{Page: page, PageSize: pageSize}, nil
+}
+
+// Offset returns the number of rows to skip
+func (p Paginator) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// Limit returns the number of rows to fetch
+func (p Paginator) Limit() int {
+	return p.PageSize
+}
+
+// TotalPages returns how many pages total rows span; zero rows is zero pages
+func (p Paginator) TotalPages(total int64) int64 {
+	return (total + int64(p.PageSize) - 1) / int64(p.PageSize)
+}
+
+// PastEnd reports whether the page starts beyond the last of total rows. The
+// first page never does, so an empty list is still a valid page.
+func (p Paginator) PastEnd(total int64) bool {
+	return p.Page > 1 && int64(p.Page) > p.TotalPages(total)
+}
+
+// Metadata is the pagination block included in list responses
+type Metadata struct {
+	Total      int64 `json:"total" xml:"total,attr"`
+	Page       int   `json:"page" xml:"page,attr"`
+	PageSize   int   `json:"page_size" xml:"page_size,attr"`
+	TotalPages int64 `json:"total_pages" xml:"total_pages,attr"`
+}
+
+// Metadata returns the response metadata for a list of total rows
+func (p Paginator) Metadata(total int64) Metadata {
+	return Metadata{
+		Total:      total,
+		Page:       p.Page,
+		PageSize:   p.PageSize,
+		TotalPages: p.TotalPages(total),
+	}
+}
+
+// LinkHeader builds an RFC 8288 Link header with first, prev, next and last
+// relations, preserving the other query parameters of u
+func (p Paginator) LinkHeader(u *url.URL, total int64) string {
+	lastPage := max(p.TotalPages(total), 1)
+
+	var links []string
+	add := func(page int64, rel string) {
+		query := u.Query()
+		query.Set("page", strconv.FormatInt(page, 10))
+		query.Set("page_size", strconv.Itoa(p.PageSize))
+		link := url.URL{Path: u.Path, RawQuery: query.Encode()}
+		links = append(links, "<"+link.String()+`>; rel="`+rel+`"`)
+	}
+
+	add(1, "first")
+	if p.Page > 1 {
+		add(min(int64(p.Page-1), lastPage), "prev")
+	}
+	if int64(p.Page) < lastPage {
+		add(int64(p.Page+1), "next")
+	}
+	add(lastPage, "last")
+
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AuditRepository appends to the audit log. Entries are never updated or
+// deleted, so there is deliberately no way to do either here.
+type AuditRepository interface {
+	Record(ctx context.Context, entry *models.AuditLog) error
+}
+
+type GormAuditRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewAuditRepository(db *gorm.DB, logger *zap.Logger) AuditRepository {
+	return &GormAuditRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *GormAuditRepository) Record(ctx context.Context, entry *models.AuditLog) error {
+	if err := conn(ctx, r.db).Create(entry).Error; err != nil {
+		r.logger.Error("Failed to record audit log entry", zap.String("action", entry.Action), zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type BackupCodeRepository interface {
+	Replace(ctx context.Context, userID uint, codeHashes []string) error
+	Consume(ctx context.Context, userID uint, codeHash string, at time.Time) error
+	DeleteAllForUser(ctx context.Context, userID uint) error
+}
+
+type GormBackupCodeRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewBackupCodeRepository(db *gorm.DB, logger *zap.Logger) BackupCodeRepository {
+	return &GormBackupCodeRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Replace discards the user's existing codes, used or not, and stores the new set
+func (r *GormBackupCodeRepository) Replace(ctx context.Context, userID uint, codeHashes []string) error {
+	codes := make([]models.BackupCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = models.BackupCode{UserID: userID, CodeHash: hash}
+	}
+
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.BackupCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		r.logger.Error("Failed to replace backup codes", zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
+
+// Consume marks an unused code as used. It returns ErrNotFound if the user has
+// no such code or it was already used, so a code can only be redeemed once.
+func (r *GormBackupCodeRepository) Consume(ctx context.Context, userID uint, codeHash string, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.BackupCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		UpdateColumn("used_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to consume backup code", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormBackupCodeRepository) DeleteAllForUser(ctx context.Context, userID uint) error {
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Delete(&models.BackupCode{}).Error; err != nil {
+		r.logger.Error("Failed to delete backup codes", zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type EmailVerificationRepository interface {
+	Store(ctx context.Context, verification *models.EmailVerification) error
+	Lookup(ctx context.Context, tokenHash string) (*models.EmailVerification, error)
+	Consume(ctx context.Context, id uint, at time.Time) error
+}
+
+type GormEmailVerificationRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewEmailVerificationRepository(db *gorm.DB, logger *zap.Logger) EmailVerificationRepository {
+	return &GormEmailVerificationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *GormEmailVerificationRepository) Store(ctx context.Context, verification *models.EmailVerification) error {
+	if err := conn(ctx, r.db).Create(verification).Error; err != nil {
+		r.logger.Error("Failed to store email verification", zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
+
+// Lookup returns the verification with the given token hash, including
+// consumed and expired ones so callers can say why a token was refused
+func (r *GormEmailVerificationRepository) Lookup(ctx context.Context, tokenHash string) (*models.EmailVerification, error) {
+	var verification models.EmailVerification
+	result := conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&verification)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		r.logger.Error("Failed to look up email verification", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+	return &verification, nil
+}
+
+// Consume marks the verification as used. It returns ErrNotFound if it was
+// already consumed, so a token can't be used twice even concurrently.
+func (r *GormEmailVerificationRepository) Consume(ctx context.Context, id uint, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.EmailVerification{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		UpdateColumn("consumed_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to consume email verification", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/models"
+	"go_postgres/internal/pagination"
+
+	"gorm.io/gorm"
+)
+
+// InMemoryUserRepository is a UserRepository backed by a map, for running the
+// service layer without Postgres. It mirrors the database's behaviour: soft
+// deletes, unique usernames and case-insensitive unique emails across all
+// rows, and the same sentinel errors. It has no transactions; every call
+// applies immediately.
+type InMemoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[uint]*models.User
+	nextID uint
+}
+
+func NewInMemoryUserRepository() UserRepository {
+	return &InMemoryUserRepository{
+		users: make(map[uint]*models.User),
+	}
+}
+
+// now matches GORM's NowFunc, which stores UTC cut to Postgres' precision
+func (r *InMemoryUserRepository) now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (r *InMemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(ctx, user)
+}
+
+func (r *InMemoryUserRepository) create(ctx context.Context, user *models.User) error {
+	if user.ID != 0 {
+		if _, taken := r.users[user.ID]; taken {
+			return ErrDuplicateID
+		}
+	}
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+
+	if user.ID == 0 {
+		r.nextID++
+		for r.users[r.nextID] != nil {
+			r.nextID++
+		}
+		user.ID = r.nextID
+	}
+
+	// As in BeforeCreate and autoCreateTime
+	now := r.now()
+	if at, ok := ctxkeys.RequestTime(ctx); ok {
+		now = at.UTC().Truncate(time.Microsecond)
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+
+	r.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (r *InMemoryUserRepository) CreateBatch(ctx context.Context, users []*models.User) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := make([]error, len(users))
+	for i, user := range users {
+		errs[i] = r.create(ctx, user)
+	}
+	return errs
+}
+
+func (r *InMemoryUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (r *InMemoryUserRepository) GetByIDWithDeleted(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (r *InMemoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findLive(func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (r *InMemoryUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.findLive(func(u *models.User) bool { return u.Username == username })
+}
+
+func (r *InMemoryUserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	_, err := r.GetByEmail(ctx, email)
+	return err == nil, nil
+}
+
+func (r *InMemoryUserRepository) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	_, err := r.GetByUsername(ctx, username)
+	return err == nil, nil
+}
+
+// findLive returns a copy of the first user that isn't soft-deleted and matches
+func (r *InMemoryUserRepository) findLive(match func(*models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if !user.DeletedAt.Valid && match(user) {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryUserRepository) List(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
+	users := r.filter(func(u *models.User) bool { return !u.DeletedAt.Valid })
+	sortNewestFirst(users)
+	return page(users, offset, limit), int64(len(users)), nil
+}
+
+func (r *InMemoryUserRepository) ListDeleted(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
+	users := r.filter(func(u *models.User) bool { return u.DeletedAt.Valid })
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if !a.DeletedAt.Time.Equal(b.DeletedAt.Time) {
+			return a.DeletedAt.Time.After(b.DeletedAt.Time)
+		}
+		return a.ID > b.ID
+	})
+	return page(users, offset, limit), int64(len(users)), nil
+}
+
+func (r *InMemoryUserRepository) ListAfter(ctx context.Context, after *pagination.Cursor, limit int) ([]*models.User, error) {
+	users := r.filter(func(u *models.User) bool {
+		if u.DeletedAt.Valid {
+			return false
+		}
+		if after == nil {
+			return true
+		}
+		// (created_at, id) < (cursor.CreatedAt, cursor.ID)
+		return u.CreatedAt.Before(after.CreatedAt) || (u.CreatedAt.Equal(after.CreatedAt) && u.ID < after.ID)
+	})
+	sortNewestFirst(users)
+	return page(users, 0, limit), nil
+}
+
+func (r *InMemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.users[user.ID]
+	if !ok || current.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = r.now()
+	r.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+// UpdateWhere matches columns to fields the way GORM does: the column tag if
+// there is one, the snake_cased field name otherwise. Like GORM's Updates, it
+// bumps updated_at unless changes sets it.
+func (r *InMemoryUserRepository) UpdateWhere(ctx context.Context, id uint, changes map[string]interface{}, conditions map[string]interface{}) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.users[id]
+	if !ok || current.DeletedAt.Valid {
+		return 0, nil
+	}
+
+	value := reflect.ValueOf(current).Elem()
+	for column, want := range conditions {
+		field, err := userColumn(value, column)
+		if err != nil {
+			return 0, err
+		}
+		if !columnEquals(field, want) {
+			return 0, nil
+		}
+	}
+
+	updated := cloneUser(current)
+	value = reflect.ValueOf(updated).Elem()
+	for column, change := range changes {
+		field, err := userColumn(value, column)
+		if err != nil {
+			return 0, err
+		}
+		if err := setColumn(field, change); err != nil {
+			return 0, fmt.Errorf("%w: column %s: %v", ErrDatabase, column, err)
+		}
+	}
+	if _, ok := changes["updated_at"]; !ok {
+		updated.UpdatedAt = r.now()
+	}
+	if err := r.checkUnique(updated); err != nil {
+		return 0, err
+	}
+
+	r.users[id] = updated
+	return 1, nil
+}
+
+func (r *InMemoryUserRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	user.DeletedAt = gorm.DeletedAt{Time: r.now(), Valid: true}
+	return nil
+}
+
+func (r *InMemoryUserRepository) HardDelete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+func (r *InMemoryUserRepository) Restore(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || !user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *InMemoryUserRepository) UpdateLastLogin(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	// Like UpdateColumn, leaves updated_at alone
+	user.LastLoginAt = &at
+	return nil
+}
+
+func (r *InMemoryUserRepository) ListInactiveSince(ctx context.Context, cutoff time.Time, afterID uint, limit int) ([]*models.User, error) {
+	users := r.filter(func(u *models.User) bool {
+		lastSeen := u.CreatedAt
+		if u.LastLoginAt != nil {
+			lastSeen = *u.LastLoginAt
+		}
+		return !u.DeletedAt.Valid && u.AnonymizedAt == nil && lastSeen.Before(cutoff) && u.ID > afterID
+	})
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return page(users, 0, limit), nil
+}
+
+// checkUnique reports a ConflictError if another user, soft-deleted or not,
+// has user's username or email, as the unique indexes on app_users would
+func (r *InMemoryUserRepository) checkUnique(user *models.User) error {
+	for _, other := range r.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return &ConflictError{Field: "username", Constraint: "app_users_username_key"}
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return &ConflictError{Field: "email", Constraint: "app_users_email_key"}
+		}
+	}
+	return nil
+}
+
+// filter returns copies of the users that match, in no particular order
+func (r *InMemoryUserRepository) filter(match func(*models.User) bool) []*models.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []*models.User
+	for _, user := range r.users {
+		if match(user) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	return users
+}
+
+// sortNewestFirst orders users by created_at DESC, id DESC
+func sortNewestFirst(users []*models.User) {
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+}
+
+// page applies OFFSET and LIMIT; a negative limit means no limit, as in GORM
+func page(users []*models.User, offset, limit int) []*models.User {
+	if offset > len(users) {
+		offset = len(users)
+	}
+	if offset > 0 {
+		users = users[offset:]
+	}
+	if limit >= 0 && limit < len(users) {
+		users = users[:limit]
+	}
+	return users
+}
+
+// cloneUser copies user so callers can't change stored users through pointers
+func cloneUser(user *models.User) *models.User {
+	clone := *user
+	if user.LastLoginAt != nil {
+		at := *user.LastLoginAt
+		clone.LastLoginAt = &at
+	}
+	if user.AnonymizedAt != nil {
+		at := *user.AnonymizedAt
+		clone.AnonymizedAt = &at
+	}
+	if user.TOTPSecret != nil {
+		secret := *user.TOTPSecret
+		clone.TOTPSecret = &secret
+	}
+	if user.EmailVerifiedAt != nil {
+		at := *user.EmailVerifiedAt
+		clone.EmailVerifiedAt = &at
+	}
+	return &clone
+}
+
+// userColumn finds the field of a models.User value stored in column
+func userColumn(user reflect.Value, column string) (reflect.Value, error) {
+	typ := user.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if columnName(field) == column {
+			return user.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%w: unknown column %q", ErrDatabase, column)
+}
+
+// columnName is the column GORM maps field to
+func columnName(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if name, ok := strings.CutPrefix(setting, "column:"); ok {
+			return name
+		}
+	}
+
+	runes := []rune(field.Name)
+	var b strings.Builder
+	for i, c := range runes {
+		// Break before an upper case letter that starts a word: after a lower
+		// case letter, or ending an acronym as in TOTPSecret
+		if i > 0 && unicode.IsUpper(c) &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+// columnEquals compares a field with a condition value, treating a nil
+// pointer as NULL and comparing times by instant
+func columnEquals(field reflect.Value, want interface{}) bool {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return want == nil
+		}
+		field = field.Elem()
+	}
+	if want == nil {
+		return false
+	}
+
+	wantValue := reflect.ValueOf(want)
+	if wantValue.Kind() == reflect.Pointer {
+		if wantValue.IsNil() {
+			return false
+		}
+		wantValue = wantValue.Elem()
+	}
+	if t, ok := field.Interface().(time.Time); ok {
+		w, ok := wantValue.Interface().(time.Time)
+		return ok && t.Equal(w)
+	}
+	if !wantValue.Type().ConvertibleTo(field.Type()) {
+		return false
+	}
+	return reflect.DeepEqual(field.Interface(), wantValue.Convert(field.Type()).Interface())
+}
+
+// setColumn stores value in field, allocating pointer fields as needed and
+// clearing them for nil
+func setColumn(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	target := field.Type()
+	if target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+	if !v.Type().ConvertibleTo(target) {
+		return fmt.Errorf("cannot store %T", value)
+	}
+	converted := v.Convert(target)
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(target)
+		ptr.Elem().Set(converted)
+		converted = ptr
+	}
+	field.Set(converted)
+	return nil
+}
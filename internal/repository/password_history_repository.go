@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type PasswordHistoryRepository interface {
+	Add(ctx context.Context, userID uint, passwordHash string) error
+	Recent(ctx context.Context, userID uint, limit int) ([]string, error)
+	Prune(ctx context.Context, userID uint, keep int) error
+}
+
+type GormPasswordHistoryRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewPasswordHistoryRepository(db *gorm.DB, logger *zap.Logger) PasswordHistoryRepository {
+	return &GormPasswordHistoryRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *GormPasswordHistoryRepository) Add(ctx context.Context, userID uint, passwordHash string) error {
+	entry := &models.PasswordHistory{UserID: userID, PasswordHash: passwordHash}
+	if err := conn(ctx, r.db).Create(entry).Error; err != nil {
+		r.logger.Error("Failed to add password history", zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
+
+// Recent returns the user's most recent password hashes, newest first
+func (r *GormPasswordHistoryRepository) Recent(ctx context.Context, userID uint, limit int) ([]string, error) {
+	var hashes []string
+	result := conn(ctx, r.db).
+		Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Pluck("password_hash", &hashes)
+
+	if result.Error != nil {
+		r.logger.Error("Failed to get password history", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+	return hashes, nil
+}
+
+// Prune deletes all but the user's keep most recent entries
+func (r *GormPasswordHistoryRepository) Prune(ctx context.Context, userID uint, keep int) error {
+	recent := r.db.
+		Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(keep)
+
+	result := conn(ctx, r.db).
+		Where("user_id = ? AND id NOT IN (?)", userID, recent).
+		Delete(&models.PasswordHistory{})
+
+	if result.Error != nil {
+		r.logger.Error("Failed to prune password history", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	return nil
+}
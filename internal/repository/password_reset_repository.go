@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type PasswordResetRepository interface {
+	Store(ctx context.Context, reset *models.PasswordReset) error
+	Lookup(ctx context.Context, tokenHash string) (*models.PasswordReset, error)
+	Consume(ctx context.Context, id uint, at time.Time) error
+	ConsumeAllForUser(ctx context.Context, userID uint, at time.Time) error
+}
+
+type GormPasswordResetRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewPasswordResetRepository(db *gorm.DB, logger *zap.Logger) PasswordResetRepository {
+	return &GormPasswordResetRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *GormPasswordResetRepository) Store(ctx context.Context, reset *models.PasswordReset) error {
+	if err := conn(ctx, r.db).Create(reset).Error; err != nil {
+		r.logger.Error("Failed to store password reset", zap.Error(err))
+		return dbError(err)
+	}
+	return nil
+}
+
+// Lookup returns the reset with the given token hash, including consumed and
+// expired ones so callers can say why a token was refused
+func (r *GormPasswordResetRepository) Lookup(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	result := conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&reset)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		r.logger.Error("Failed to look up password reset", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+	return &reset, nil
+}
+
+// Consume marks the reset as used. It returns ErrNotFound if it was already
+// consumed, so a token can't be used twice even concurrently.
+func (r *GormPasswordResetRepository) Consume(ctx context.Context, id uint, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.PasswordReset{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		UpdateColumn("consumed_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to consume password reset", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ConsumeAllForUser invalidates every outstanding reset token of the user
+func (r *GormPasswordResetRepository) ConsumeAllForUser(ctx context.Context, userID uint, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.PasswordReset{}).
+		Where("user_id = ? AND consumed_at IS NULL", userID).
+		UpdateColumn("consumed_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to invalidate password resets", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go_postgres/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type RefreshTokenRepository interface {
+	Store(ctx context.Context, token *models.RefreshToken) error
+	Lookup(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	Revoke(ctx context.Context, id uint, at time.Time) error
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeAllForUser(ctx context.Context, userID uint, at time.Time) error
+}
+
+type GormRefreshTokenRepository struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewRefreshTokenRepository(db *gorm.DB, logger *zap.Logger) RefreshTokenRepository {
+	return &GormRefreshTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *GormRefreshTokenRepository) Store(ctx context.Context, token *models.RefreshToken) error {
+	result := conn(ctx, r.db).Create(token)
+	if result.Error != nil {
+		if _, ok := uniqueViolation(result.Error); ok {
+			return ErrConflict
+		}
+		r.logger.Error("Failed to store refresh token", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	return nil
+}
+
+// Lookup returns the token with the given hash, including revoked and expired
+// ones so callers can tell reuse apart from an unknown token
+func (r *GormRefreshTokenRepository) Lookup(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	result := conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		r.logger.Error("Failed to look up refresh token", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+	return &token, nil
+}
+
+// Revoke marks a single active token as revoked. It returns ErrNotFound if the
+// token doesn't exist or was already revoked, so two concurrent rotations of
+// the same token can't both succeed.
+func (r *GormRefreshTokenRepository) Revoke(ctx context.Context, id uint, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		UpdateColumn("revoked_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to revoke refresh token", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeFamily revokes every active token rotated from the same login
+func (r *GormRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		UpdateColumn("revoked_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to revoke refresh token family", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every active token belonging to the user
+func (r *GormRefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uint, at time.Time) error {
+	result := conn(ctx, r.db).
+		Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to revoke refresh tokens for user", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// txKey carries the open transaction in the context so that every repository
+// called with that context joins it
+type txKey struct{}
+
+// TxManager runs a sequence of repository calls atomically
+type TxManager interface {
+	// WithTransaction commits if fn returns nil and rolls back otherwise.
+	// Repositories called with the ctx passed to fn, including txRepo, run
+	// inside the transaction; nested calls use a savepoint.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context, txRepo UserRepository) error) error
+}
+
+type GormTxManager struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewTxManager(db *gorm.DB, logger *zap.Logger) TxManager {
+	return &GormTxManager{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *GormTxManager) WithTransaction(ctx context.Context, fn func(ctx context.Context, txRepo UserRepository) error) error {
+	return conn(ctx, m.db).Transaction(func(tx *gorm.DB) error {
+		txCtx := context.WithValue(ctx, txKey{}, tx)
+		return fn(txCtx, NewUserRepository(tx, m.logger))
+	})
+}
+
+// conn returns the transaction open in ctx, if any, or db otherwise
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
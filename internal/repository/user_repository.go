@@ -3,27 +3,84 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"go_postgres/internal/models"
+	"go_postgres/internal/pagination"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 var (
-	ErrNotFound = errors.New("record not found")
-	ErrConflict = errors.New("record already exists")
-	ErrDatabase = errors.New("database error")
+	ErrNotFound    = errors.New("record not found")
+	ErrConflict    = errors.New("record already exists")
+	ErrDuplicateID = errors.New("record with this id already exists")
+	ErrDatabase    = errors.New("database error")
+	// ErrQueryTimeout is a database error caused by a query running out of
+	// time; it also matches ErrDatabase
+	ErrQueryTimeout = fmt.Errorf("%w: query timed out", ErrDatabase)
 )
 
+// dbError classifies an unexpected database error for callers, which only get
+// to see whether it was a timeout
+func dbError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrQueryTimeout
+	}
+	return ErrDatabase
+}
+
+// ConflictError reports which unique column a write collided with. It matches
+// ErrConflict with errors.Is.
+type ConflictError struct {
+	// Field is the conflicting column, e.g. "email" or "username"; empty when
+	// it can't be derived from the constraint name
+	Field      string
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field == "" {
+		return ErrConflict.Error()
+	}
+	return ErrConflict.Error() + ": " + e.Field
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Postgres SQLSTATE for unique_violation
+const uniqueViolationCode = "23505"
+
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
+	// CreateBatch inserts users in one transaction and returns an error per
+	// user, nil where the insert succeeded. A failed row doesn't stop the others.
+	CreateBatch(ctx context.Context, users []*models.User) []error
 	GetByID(ctx context.Context, id uint) (*models.User, error)
+	GetByIDWithDeleted(ctx context.Context, id uint) (*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
 	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	// ExistsByEmail and ExistsByUsername report whether a live user holds the
+	// value without loading the row
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	ExistsByUsername(ctx context.Context, username string) (bool, error)
 	List(ctx context.Context, offset, limit int) ([]*models.User, int64, error)
+	ListAfter(ctx context.Context, after *pagination.Cursor, limit int) ([]*models.User, error)
+	// ListDeleted pages through soft-deleted users, most recently deleted first
+	ListDeleted(ctx context.Context, offset, limit int) ([]*models.User, int64, error)
 	Update(ctx context.Context, user *models.User) error
+	UpdateWhere(ctx context.Context, id uint, changes map[string]interface{}, conditions map[string]interface{}) (int64, error)
 	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) error
+	HardDelete(ctx context.Context, id uint) error
+	UpdateLastLogin(ctx context.Context, id uint, at time.Time) error
+	ListInactiveSince(ctx context.Context, cutoff time.Time, afterID uint, limit int) ([]*models.User, error)
 }
 
 type GormUserRepository struct {
@@ -39,27 +96,68 @@ func NewUserRepository(db *gorm.DB, logger *zap.Logger) UserRepository {
 }
 
 func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
-	result := r.db.WithContext(ctx).Create(user)
+	result := conn(ctx, r.db).Create(user)
 	if result.Error != nil {
+		if r.isPrimaryKeyConflict(result.Error) {
+			return ErrDuplicateID
+		}
 		if r.isUniqueConstraintError(result.Error) {
-			return ErrConflict
+			return conflictError(result.Error)
 		}
 		r.logger.Error("failed to create user", zap.Error(result.Error))
-		return ErrDatabase
+		return dbError(result.Error)
 	}
 	return nil
 }
 
+func (r *GormUserRepository) CreateBatch(ctx context.Context, users []*models.User) []error {
+	errs := make([]error, len(users))
+	// Each row gets its own savepoint so a constraint violation only rolls back
+	// that row instead of aborting the whole transaction
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		txCtx := context.WithValue(ctx, txKey{}, tx)
+		for i, user := range users {
+			errs[i] = conn(txCtx, r.db).Transaction(func(sp *gorm.DB) error {
+				return r.Create(context.WithValue(txCtx, txKey{}, sp), user)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.Error("failed to commit user batch", zap.Error(err))
+		for i := range errs {
+			errs[i] = dbError(err)
+		}
+	}
+	return errs
+}
+
 func (r *GormUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
-	result := r.db.WithContext(ctx).First(&user, id)
+	result := conn(ctx, r.db).First(&user, id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
 
 		r.logger.Error("failed to get user by ID", zap.Error(result.Error))
-		return nil, ErrDatabase
+		return nil, dbError(result.Error)
+	}
+
+	return &user, nil
+}
+
+// GetByIDWithDeleted is GetByID that also finds soft-deleted users
+func (r *GormUserRepository) GetByIDWithDeleted(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	result := conn(ctx, r.db).Unscoped().First(&user, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+
+		r.logger.Error("failed to get user by ID including deleted", zap.Error(result.Error))
+		return nil, dbError(result.Error)
 	}
 
 	return &user, nil
@@ -67,42 +165,72 @@ func (r *GormUserRepository) GetByID(ctx context.Context, id uint) (*models.User
 
 func (r *GormUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	result := r.db.WithContext(ctx).Where("email = ?", email).First(&user)
+	// LOWER on both sides matches the unique index and rows stored before
+	// emails were normalized
+	result := conn(ctx, r.db).Where("LOWER(email) = LOWER(?)", email).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
 		r.logger.Error("Failed to get user by email", zap.Error(result.Error))
-		return nil, ErrDatabase
+		return nil, dbError(result.Error)
 	}
 	return &user, nil
 }
 
 func (r *GormUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	result := r.db.WithContext(ctx).Where("username = ?", username).First(&user)
+	result := conn(ctx, r.db).Where("username = ?", username).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
 		r.logger.Error("Failed to get user by username", zap.Error(result.Error))
-		return nil, ErrDatabase
+		return nil, dbError(result.Error)
 	}
 	return &user, nil
 }
 
+func (r *GormUserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	exists, err := r.exists(ctx, "LOWER(email) = LOWER(?)", email)
+	if err != nil {
+		r.logger.Error("Failed to check user exists by email", zap.Error(err))
+		return false, dbError(err)
+	}
+	return exists, nil
+}
+
+func (r *GormUserRepository) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	exists, err := r.exists(ctx, "username = ?", username)
+	if err != nil {
+		r.logger.Error("Failed to check user exists by username", zap.Error(err))
+		return false, dbError(err)
+	}
+	return exists, nil
+}
+
+// exists runs SELECT 1 ... LIMIT 1 for the condition, skipping soft-deleted users
+func (r *GormUserRepository) exists(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	var one int
+	result := conn(ctx, r.db).Model(&models.User{}).Select("1").Where(query, args...).Limit(1).Scan(&one)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
 func (r *GormUserRepository) List(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
 	var users []*models.User
 	var count int64
 
 	// Count total records
-	if err := r.db.WithContext(ctx).Model(&models.User{}).Count(&count).Error; err != nil {
+	if err := conn(ctx, r.db).Model(&models.User{}).Count(&count).Error; err != nil {
 		r.logger.Error("Failed to count users", zap.Error(err))
-		return nil, 0, ErrDatabase
+		return nil, 0, dbError(err)
 	}
 
 	// Get paginated records
-	result := r.db.WithContext(ctx).
+	result := conn(ctx, r.db).
 		Offset(offset).
 		Limit(limit).
 		Order("created_at DESC").
@@ -110,20 +238,73 @@ func (r *GormUserRepository) List(ctx context.Context, offset, limit int) ([]*mo
 
 	if result.Error != nil {
 		r.logger.Error("Failed to list users", zap.Error(result.Error))
-		return nil, 0, ErrDatabase
+		return nil, 0, dbError(result.Error)
+	}
+
+	return users, count, nil
+}
+
+func (r *GormUserRepository) ListDeleted(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
+	var users []*models.User
+	var count int64
+
+	deleted := func() *gorm.DB {
+		return conn(ctx, r.db).Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL")
+	}
+
+	if err := deleted().Count(&count).Error; err != nil {
+		r.logger.Error("Failed to count deleted users", zap.Error(err))
+		return nil, 0, dbError(err)
+	}
+
+	result := deleted().
+		Offset(offset).
+		Limit(limit).
+		Order("deleted_at DESC, id DESC").
+		Find(&users)
+
+	if result.Error != nil {
+		r.logger.Error("Failed to list deleted users", zap.Error(result.Error))
+		return nil, 0, dbError(result.Error)
 	}
 
 	return users, count, nil
 }
 
+// ListAfter returns up to limit users in the same order as List, starting
+// after the cursor, or from the top when after is nil. Unlike offsets, the
+// cursor stays put when rows are inserted or deleted on earlier pages.
+func (r *GormUserRepository) ListAfter(ctx context.Context, after *pagination.Cursor, limit int) ([]*models.User, error) {
+	var users []*models.User
+	query := conn(ctx, r.db)
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID)
+	}
+
+	result := query.
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&users)
+
+	if result.Error != nil {
+		r.logger.Error("Failed to list users after cursor", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+
+	return users, nil
+}
+
 func (r *GormUserRepository) Update(ctx context.Context, user *models.User) error {
-	result := r.db.WithContext(ctx).Save(user)
+	result := conn(ctx, r.db).Save(user)
 	if result.Error != nil {
+		if r.isPrimaryKeyConflict(result.Error) {
+			return ErrDuplicateID
+		}
 		if r.isUniqueConstraintError(result.Error) {
-			return ErrConflict
+			return conflictError(result.Error)
 		}
 		r.logger.Error("Failed to update user", zap.Error(result.Error))
-		return ErrDatabase
+		return dbError(result.Error)
 	}
 	if result.RowsAffected == 0 {
 		return ErrNotFound
@@ -131,11 +312,67 @@ func (r *GormUserRepository) Update(ctx context.Context, user *models.User) erro
 	return nil
 }
 
+// UpdateWhere applies changes to the user only if every column in conditions
+// currently has the given value, as a single UPDATE ... WHERE id = ? AND ...
+// statement. It returns the number of rows affected; zero means the user
+// doesn't exist or the conditions didn't hold. Keys are column names and must
+// not come from user input.
+func (r *GormUserRepository) UpdateWhere(ctx context.Context, id uint, changes map[string]interface{}, conditions map[string]interface{}) (int64, error) {
+	query := conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id)
+	if len(conditions) > 0 {
+		query = query.Where(conditions)
+	}
+
+	result := query.Updates(changes)
+	if result.Error != nil {
+		if r.isUniqueConstraintError(result.Error) {
+			return 0, conflictError(result.Error)
+		}
+		r.logger.Error("Failed to conditionally update user", zap.Error(result.Error))
+		return 0, dbError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
 func (r *GormUserRepository) Delete(ctx context.Context, id uint) error {
-	result := r.db.WithContext(ctx).Delete(&models.User{}, id)
+	result := conn(ctx, r.db).Delete(&models.User{}, id)
 	if result.Error != nil {
 		r.logger.Error("Failed to delete user", zap.Error(result.Error))
-		return ErrDatabase
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// HardDelete permanently removes the user, whether soft-deleted or not. Rows
+// referencing the user are removed by ON DELETE CASCADE.
+func (r *GormUserRepository) HardDelete(ctx context.Context, id uint) error {
+	result := conn(ctx, r.db).Unscoped().Delete(&models.User{}, id)
+	if result.Error != nil {
+		r.logger.Error("Failed to hard delete user", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Restore clears DeletedAt on a soft-deleted user. It returns ErrNotFound if
+// no soft-deleted user has the ID and a ConflictError if the user's email or
+// username has since been taken.
+func (r *GormUserRepository) Restore(ctx context.Context, id uint) error {
+	result := conn(ctx, r.db).Unscoped().Model(&models.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		UpdateColumn("deleted_at", nil)
+	if result.Error != nil {
+		if r.isUniqueConstraintError(result.Error) {
+			return conflictError(result.Error)
+		}
+		r.logger.Error("Failed to restore user", zap.Error(result.Error))
+		return dbError(result.Error)
 	}
 	if result.RowsAffected == 0 {
 		return ErrNotFound
@@ -143,7 +380,76 @@ func (r *GormUserRepository) Delete(ctx context.Context, id uint) error {
 	return nil
 }
 
+func (r *GormUserRepository) UpdateLastLogin(ctx context.Context, id uint, at time.Time) error {
+	// UpdateColumn skips hooks and leaves updated_at alone; a login isn't a profile change
+	result := conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id).UpdateColumn("last_login_at", at)
+	if result.Error != nil {
+		r.logger.Error("Failed to update last login", zap.Error(result.Error))
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListInactiveSince returns users that haven't logged in (or, if they never did,
+// were created) before cutoff and aren't anonymized yet, ordered by ID and
+// starting after afterID
+func (r *GormUserRepository) ListInactiveSince(ctx context.Context, cutoff time.Time, afterID uint, limit int) ([]*models.User, error) {
+	var users []*models.User
+	result := conn(ctx, r.db).
+		Where("anonymized_at IS NULL").
+		Where("COALESCE(last_login_at, created_at) < ?", cutoff).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&users)
+
+	if result.Error != nil {
+		r.logger.Error("Failed to list inactive users", zap.Error(result.Error))
+		return nil, dbError(result.Error)
+	}
+
+	return users, nil
+}
+
 func (r *GormUserRepository) isUniqueConstraintError(err error) bool {
-	// Postgres unique constraint error code
-	return err != nil && err.Error() == "ERROR: duplicate key value violates unique constraint"
+	_, ok := uniqueViolation(err)
+	return ok
+}
+
+// isPrimaryKeyConflict reports whether err is a unique violation on the primary
+// key (e.g. an explicit ID that is already taken) rather than on email/username
+func (r *GormUserRepository) isPrimaryKeyConflict(err error) bool {
+	pgErr, ok := uniqueViolation(err)
+	return ok && strings.HasSuffix(pgErr.ConstraintName, "_pkey")
+}
+
+// conflictError describes a unique violation by the column it happened on.
+// Postgres names inline UNIQUE constraints <table>_<column>_key and GORM names
+// unique indexes idx_<table>_<column>.
+func conflictError(err error) error {
+	pgErr, ok := uniqueViolation(err)
+	if !ok {
+		return ErrConflict
+	}
+
+	var field string
+	if pgErr.TableName != "" {
+		field = strings.TrimPrefix(pgErr.ConstraintName, "idx_")
+		field = strings.TrimPrefix(field, pgErr.TableName+"_")
+		field = strings.TrimSuffix(field, "_key")
+	}
+
+	return &ConflictError{Field: field, Constraint: pgErr.ConstraintName}
+}
+
+// uniqueViolation unwraps err to the underlying Postgres unique violation, if any
+func uniqueViolation(err error) (*pgconn.PgError, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return pgErr, true
+	}
+	return nil, false
 }
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/models"
+)
+
+// auditRedacted stands in for values that must not be copied into the audit log
+const auditRedacted = "[REDACTED]"
+
+// auditChange is one field's entry in an audit log diff. From is omitted for
+// fields set on creation.
+type auditChange struct {
+	From any `json:"from,omitempty"`
+	To   any `json:"to"`
+}
+
+// audit appends an entry for action on targetID, attributed to the user
+// authenticated in ctx. Pass the ctx of an open transaction so the entry
+// commits or rolls back with the change it describes.
+func (s *DefaultUserService) audit(ctx context.Context, action string, targetID uint, changes map[string]auditChange) error {
+	entry := &models.AuditLog{
+		TargetID: targetID,
+		Action:   action,
+	}
+	if actorID, ok := ctxkeys.UserID(ctx); ok {
+		entry.ActorID = &actorID
+	}
+	if changes != nil {
+		data, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		entry.Changes = data
+	}
+	return s.auditLog.Record(ctx, entry)
+}
+
+// auditFields are the user's audited fields by column name. The password hash
+// is reported as changed but its value is never logged.
+func auditFields(u *models.User) map[string]any {
+	return map[string]any{
+		"username":      u.Username,
+		"email":         u.Email,
+		"first_name":    u.FirstName,
+		"last_name":     u.LastName,
+		"is_active":     u.IsActive,
+		"role":          u.Role,
+		"password_hash": u.PasswordHash,
+	}
+}
+
+// diffUsers returns the audited fields that differ between before and after.
+// A nil before describes a newly created user, reporting every field.
+func diffUsers(before, after *models.User) map[string]auditChange {
+	changes := map[string]auditChange{}
+	var old map[string]any
+	if before != nil {
+		old = auditFields(before)
+	}
+	for field, to := range auditFields(after) {
+		from, existed := old[field]
+		if existed && from == to {
+			continue
+		}
+
+		if field == "password_hash" {
+			to = auditRedacted
+			if existed {
+				from = auditRedacted
+			}
+		}
+		changes[field] = auditChange{From: from, To: to}
+	}
+	return changes
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/models"
+	"go_postgres/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+type TokenService interface {
+	// IssueRefreshToken starts a new token family for a fresh login
+	IssueRefreshToken(ctx context.Context, userID uint) (string, error)
+	// RotateRefreshToken exchanges a refresh token for a new one in the same
+	// family and returns the user it belongs to
+	RotateRefreshToken(ctx context.Context, refreshToken string) (uint, string, error)
+}
+
+type DefaultTokenService struct {
+	repo   repository.RefreshTokenRepository
+	logger *zap.Logger
+	clock  clock.Clock
+	cfg    *config.AuthConfig
+}
+
+func NewTokenService(repo repository.RefreshTokenRepository, logger *zap.Logger, clk clock.Clock, cfg *config.AuthConfig) TokenService {
+	return &DefaultTokenService{
+		repo:   repo,
+		logger: logger,
+		clock:  clk,
+		cfg:    cfg,
+	}
+}
+
+func (s *DefaultTokenService) IssueRefreshToken(ctx context.Context, userID uint) (string, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	return s.issue(ctx, userID, familyID)
+}
+
+func (s *DefaultTokenService) RotateRefreshToken(ctx context.Context, refreshToken string) (uint, string, error) {
+	stored, err := s.repo.Lookup(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return 0, "", ErrInvalidRefreshToken
+		}
+		return 0, "", err
+	}
+
+	now := s.clock.Now()
+
+	// A revoked token being presented means it was copied: either the client or
+	// an attacker holds a stale one. Kill the family so neither can continue.
+	if stored.RevokedAt != nil {
+		s.revokeFamilyOnReuse(ctx, stored)
+		return 0, "", ErrInvalidRefreshToken
+	}
+
+	if !now.Before(stored.ExpiresAt) {
+		return 0, "", ErrInvalidRefreshToken
+	}
+
+	if err := s.repo.Revoke(ctx, stored.ID, now); err != nil {
+		// Lost a race with a concurrent rotation of the same token: also reuse
+		if errors.Is(err, repository.ErrNotFound) {
+			s.revokeFamilyOnReuse(ctx, stored)
+			return 0, "", ErrInvalidRefreshToken
+		}
+		return 0, "", err
+	}
+
+	next, err := s.issue(ctx, stored.UserID, stored.FamilyID)
+	if err != nil {
+		return 0, "", err
+	}
+	return stored.UserID, next, nil
+}
+
+func (s *DefaultTokenService) revokeFamilyOnReuse(ctx context.Context, stored *models.RefreshToken) {
+	s.logger.Warn("Refresh token reuse detected; revoking token family",
+		zap.Uint("user_id", stored.UserID),
+		zap.String("family_id", stored.FamilyID),
+	)
+	if err := s.repo.RevokeFamily(ctx, stored.FamilyID, s.clock.Now()); err != nil {
+		s.logger.Error("Failed to revoke refresh token family", zap.Error(err))
+	}
+}
+
+func (s *DefaultTokenService) issue(ctx context.Context, userID uint, familyID string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.Store(ctx, &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: s.clock.Now().Add(s.cfg.RefreshTokenTTL),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// randomToken returns n random bytes encoded for use in URLs and JSON
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored, so a database leak doesn't expose usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
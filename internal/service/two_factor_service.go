@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/lockout"
+	"go_postgres/internal/models"
+	"go_postgres/internal/repository"
+	"go_postgres/internal/secretbox"
+	"go_postgres/internal/totp"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrTwoFactorUnavailable    = errors.New("two-factor authentication is not configured")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+)
+
+// TwoFactorEnrollment is shown to the user once so they can add the account
+// to an authenticator app and store their backup codes
+type TwoFactorEnrollment struct {
+	Secret      string   `json:"secret"`
+	OTPAuthURL  string   `json:"otpauth_url"`
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// backupCodeEncoding spells backup codes in lowercase base32, which avoids
+// characters that are easily confused when copied by hand
+var backupCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type TwoFactorService interface {
+	// Enroll generates a new secret; 2FA stays off until Confirm succeeds
+	Enroll(ctx context.Context, userID uint) (*TwoFactorEnrollment, error)
+	Confirm(ctx context.Context, userID uint, code string) error
+	Disable(ctx context.Context, userID uint, code string) error
+	// Verify checks the code presented at the second step of a login. An unused
+	// backup code is accepted in place of a TOTP code.
+	Verify(ctx context.Context, userID uint, code string) error
+	// RegenerateBackupCodes replaces the user's backup codes after checking a
+	// current code
+	RegenerateBackupCodes(ctx context.Context, userID uint, code string) ([]string, error)
+}
+
+type DefaultTwoFactorService struct {
+	repo     repository.UserRepository
+	backups  repository.BackupCodeRepository
+	tx       repository.TxManager
+	box      *secretbox.Box
+	logger   *zap.Logger
+	clock    clock.Clock
+	cfg      *config.AuthConfig
+	issuer   string
+	lockouts *lockout.Tracker
+}
+
+// NewTwoFactorService returns a service that refuses every operation with
+// ErrTwoFactorUnavailable when box is nil, i.e. no encryption key is configured
+func NewTwoFactorService(repo repository.UserRepository, backups repository.BackupCodeRepository, tx repository.TxManager, box *secretbox.Box, logger *zap.Logger, clk clock.Clock, cfg *config.AuthConfig, issuer string, lockouts *lockout.Tracker) TwoFactorService {
+	return &DefaultTwoFactorService{
+		repo:     repo,
+		backups:  backups,
+		tx:       tx,
+		box:      box,
+		logger:   logger,
+		clock:    clk,
+		cfg:      cfg,
+		issuer:   issuer,
+		lockouts: lockouts,
+	}
+}
+
+func (s *DefaultTwoFactorService) Enroll(ctx context.Context, userID uint) (*TwoFactorEnrollment, error) {
+	if s.box == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.box.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var backupCodes []string
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		// Re-enrolling before confirming simply replaces the pending secret
+		rows, err := txRepo.UpdateWhere(ctx, userID,
+			map[string]interface{}{"totp_secret": sealed},
+			map[string]interface{}{"totp_enabled": false},
+		)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		backupCodes, err = s.replaceBackupCodes(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:      secret,
+		OTPAuthURL:  totp.URL(s.issuer, user.Email, secret),
+		BackupCodes: backupCodes,
+	}, nil
+}
+
+func (s *DefaultTwoFactorService) Confirm(ctx context.Context, userID uint, code string) error {
+	if s.box == nil {
+		return ErrTwoFactorUnavailable
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTwoFactorAlreadyEnabled
+	}
+
+	step, err := s.checkCode(user, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.repo.UpdateWhere(ctx, userID,
+		map[string]interface{}{"totp_enabled": true, "totp_last_step": step},
+		map[string]interface{}{"totp_enabled": false, "totp_secret": user.TOTPSecret},
+	)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// Enabled or re-enrolled concurrently; the code was for a stale secret
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func (s *DefaultTwoFactorService) Disable(ctx context.Context, userID uint, code string) error {
+	if s.box == nil {
+		return ErrTwoFactorUnavailable
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if err := s.authenticate(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if _, err := txRepo.UpdateWhere(ctx, userID,
+			map[string]interface{}{"totp_enabled": false, "totp_secret": nil, "totp_last_step": 0},
+			nil,
+		); err != nil {
+			return err
+		}
+		return s.backups.DeleteAllForUser(ctx, userID)
+	})
+}
+
+func (s *DefaultTwoFactorService) Verify(ctx context.Context, userID uint, code string) error {
+	if s.box == nil {
+		return ErrTwoFactorUnavailable
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	return s.authenticate(ctx, user, code)
+}
+
+func (s *DefaultTwoFactorService) RegenerateBackupCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	if s.box == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	if err := s.authenticate(ctx, user, code); err != nil {
+		return nil, err
+	}
+	return s.replaceBackupCodes(ctx, userID)
+}
+
+// authenticate accepts either a TOTP code or an unused backup code and uses it
+// up, so neither can be presented twice
+func (s *DefaultTwoFactorService) authenticate(ctx context.Context, user *models.User, code string) error {
+	if !isTOTPCode(code) {
+		return s.consumeBackupCode(ctx, user, code)
+	}
+
+	step, err := s.checkCode(user, code)
+	if err != nil {
+		return err
+	}
+
+	// Advance the last used step only if nobody else did meanwhile, so a code
+	// can't be replayed, not even concurrently
+	rows, err := s.repo.UpdateWhere(ctx, user.ID,
+		map[string]interface{}{"totp_last_step": step},
+		map[string]interface{}{"totp_last_step": user.TOTPLastStep},
+	)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func (s *DefaultTwoFactorService) consumeBackupCode(ctx context.Context, user *models.User, code string) error {
+	if _, locked := s.lockouts.Locked(user.ID); locked {
+		return ErrAccountLocked
+	}
+
+	err := s.backups.Consume(ctx, user.ID, hashToken(normalizeBackupCode(code)), s.clock.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		if s.lockouts.Fail(user.ID) {
+			s.logger.Warn("Account locked after repeated failed two-factor codes", zap.Uint("user_id", user.ID))
+		}
+		return ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+
+	s.lockouts.Reset(user.ID)
+	s.logger.Info("backup code used", zap.Uint("user_id", user.ID))
+	return nil
+}
+
+// replaceBackupCodes stores a fresh set of backup codes and returns them in
+// plaintext; this is the only time they can be shown
+func (s *DefaultTwoFactorService) replaceBackupCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes := make([]string, s.cfg.BackupCodeCount)
+	hashes := make([]string, len(codes))
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := backupCodeEncoding.EncodeToString(b)
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(code)
+	}
+
+	if err := s.backups.Replace(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// isTOTPCode reports whether code looks like an authenticator code rather than
+// a backup code
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeBackupCode undoes the grouping and case changes users make when
+// typing a backup code
+func normalizeBackupCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// checkCode validates code against the user's secret, rejecting steps at or
+// before the last accepted one. Failures count towards the account lockout.
+func (s *DefaultTwoFactorService) checkCode(user *models.User, code string) (int64, error) {
+	if _, locked := s.lockouts.Locked(user.ID); locked {
+		return 0, ErrAccountLocked
+	}
+	if user.TOTPSecret == nil {
+		return 0, ErrTwoFactorNotEnrolled
+	}
+
+	secret, err := s.box.Open(*user.TOTPSecret)
+	if err != nil {
+		s.logger.Error("failed to decrypt TOTP secret", zap.Uint("user_id", user.ID), zap.Error(err))
+		return 0, err
+	}
+
+	step, ok := totp.Validate(secret, code, s.clock.Now(), s.cfg.TOTPSkew)
+	if !ok || step <= user.TOTPLastStep {
+		if s.lockouts.Fail(user.ID) {
+			s.logger.Warn("Account locked after repeated failed two-factor codes", zap.Uint("user_id", user.ID))
+		}
+		return 0, ErrInvalidTwoFactorCode
+	}
+
+	s.lockouts.Reset(user.ID)
+	return step, nil
+}
+
+func (s *DefaultTwoFactorService) getUser(ctx context.Context, userID uint) (*models.User, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
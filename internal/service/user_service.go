@@ -2,10 +2,22 @@ package service
 
 import (
 	"context"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/ctxkeys"
+	"go_postgres/internal/lockout"
+	"go_postgres/internal/mailer"
 	"go_postgres/internal/models"
+	"go_postgres/internal/pagination"
+	"go_postgres/internal/password"
 	"go_postgres/internal/repository"
 
 	"go.uber.org/zap"
@@ -16,63 +28,179 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrUserAlreadyExists  = errors.New("user already exists")
+	ErrEmailTaken         = fmt.Errorf("%w: email is already registered", ErrUserAlreadyExists)
+	ErrUsernameTaken      = fmt.Errorf("%w: username is already taken", ErrUserAlreadyExists)
+	ErrAccountInactive    = errors.New("account is not active")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrPasswordReused     = errors.New("password was used recently")
+	ErrWeakPassword       = errors.New("password does not meet the policy")
+	ErrInvalidResetToken  = errors.New("invalid password reset token")
+	ErrResetTokenExpired  = errors.New("password reset token has expired")
+	// ErrPreconditionFailed means a conditional update found the user changed
+	ErrPreconditionFailed = errors.New("user was modified since it was read")
+	// ErrQueryTimeout means the database didn't answer in time
+	ErrQueryTimeout = repository.ErrQueryTimeout
 )
 
+// WeakPasswordError lists the password policy rules a password broke. It
+// matches ErrWeakPassword with errors.Is.
+type WeakPasswordError struct {
+	// Violations describe each broken rule, e.g. "must contain a digit"
+	Violations []string
+}
+
+func (e *WeakPasswordError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Violations, ", ")
+}
+
+func (e *WeakPasswordError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// Password lengths are capped at 72 bytes because bcrypt ignores the rest
 type CreateUserRequest struct {
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	Username  string `json:"username" validate:"required,min=3,max=50"`
+	Email     string `json:"email" validate:"required,email,max=100"`
+	Password  string `json:"password" validate:"required,min=8,max=72"`
+	FirstName string `json:"first_name" validate:"max=50"`
+	LastName  string `json:"last_name" validate:"max=50"`
 }
 
 type UpdateUserRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Password  string `json:"password,omitempty"`
+	FirstName string `json:"first_name" validate:"max=50"`
+	LastName  string `json:"last_name" validate:"max=50"`
+	Password  string `json:"password,omitempty" validate:"min=8,max=72"`
+	// IfUpdatedAt makes the update conditional: it fails with
+	// ErrPreconditionFailed unless the user's UpdatedAt is one of these. Nil
+	// means unconditional.
+	IfUpdatedAt []time.Time `json:"-" xml:"-"`
 }
 
 type UserResponse struct {
-	ID        uint      `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	XMLName   xml.Name `json:"-" xml:"user"`
+	ID        uint     `json:"id" xml:"id"`
+	Username  string   `json:"username" xml:"username"`
+	Email     string   `json:"email" xml:"email"`
+	FirstName string   `json:"first_name" xml:"first_name"`
+	LastName  string   `json:"last_name" xml:"last_name"`
+	IsActive  bool     `json:"is_active" xml:"is_active"`
+	Role      string   `json:"role" xml:"role"`
+	// TwoFactorEnabled means logins need a TOTP code after the password
+	TwoFactorEnabled bool      `json:"two_factor_enabled" xml:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// BulkCreateResult is the outcome of one user in a CreateUsers batch: User is
+// set if it was created and Err otherwise
+type BulkCreateResult struct {
+	User *UserResponse
+	Err  error
+}
+
+// DeletedUserResponse is a soft-deleted user as shown to admins
+type DeletedUserResponse struct {
+	XMLName xml.Name `json:"-" xml:"deleted_user"`
+	UserResponse
+	DeletedAt time.Time `json:"deleted_at" xml:"deleted_at"`
 }
 
 type UserService interface {
 	CreateUser(ctx context.Context, req CreateUserRequest) (*UserResponse, error)
+	// CreateUsers creates each user in reqs in a single transaction and returns
+	// a result per request, in the same order. One user failing, e.g. on a
+	// duplicate email, doesn't stop the rest.
+	CreateUsers(ctx context.Context, reqs []CreateUserRequest) ([]BulkCreateResult, error)
 	GetUser(ctx context.Context, id uint) (*UserResponse, error)
-	ListUsers(ctx context.Context, page, pageSize int) ([]*UserResponse, int64, error)
+	ListUsers(ctx context.Context, paginator pagination.Paginator) ([]*UserResponse, int64, error)
+	// ListUsersAfter returns a page of users following the cursor and the cursor
+	// for the next page, which is nil on the last page
+	ListUsersAfter(ctx context.Context, after *pagination.Cursor, limit int) ([]*UserResponse, *pagination.Cursor, error)
+	// ListDeletedUsers returns a page of soft-deleted users, most recently
+	// deleted first
+	ListDeletedUsers(ctx context.Context, paginator pagination.Paginator) ([]*DeletedUserResponse, int64, error)
 	UpdateUser(ctx context.Context, id uint, req UpdateUserRequest) (*UserResponse, error)
 	DeleteUser(ctx context.Context, id uint) error
-	AuthenticateUser(ctx context.Context, email, password string) (*UserResponse, error)
+	RestoreUser(ctx context.Context, id uint) (*UserResponse, error)
+	// PurgeUser permanently erases the user, including one already soft-deleted
+	PurgeUser(ctx context.Context, id uint) error
+	AuthenticateUser(ctx context.Context, identifier, password string) (*UserResponse, error)
+	VerifyPassword(ctx context.Context, id uint, password string) error
+	// RequestPasswordReset emails a reset link if email belongs to a user. It
+	// reports success either way so callers can't probe for accounts.
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password using a token from RequestPasswordReset
+	// and ends all of the user's sessions
+	ResetPassword(ctx context.Context, token, newPassword string) error
 }
 
 type DefaultUserService struct {
-	repo   repository.UserRepository
-	logger *zap.Logger
+	repo    repository.UserRepository
+	history repository.PasswordHistoryRepository
+	// refreshTokens is used to cut off sessions when a user is purged
+	refreshTokens repository.RefreshTokenRepository
+	// tx makes writes spanning several tables atomic
+	tx repository.TxManager
+	// verification sends the activation link in require-verification mode
+	verification VerificationService
+	resets       repository.PasswordResetRepository
+	mailer       mailer.Mailer
+	// auditLog records every mutation in the transaction that makes it
+	auditLog repository.AuditRepository
+	logger   *zap.Logger
+	clock    clock.Clock
+	cfg      *config.AppConfig
+	authCfg  *config.AuthConfig
+	// lockouts counts failed password checks across login and re-confirmation
+	lockouts *lockout.Tracker
+	// dummyHash is compared against when the user doesn't exist so that unknown
+	// identifiers take as long to reject as wrong passwords
+	dummyHash []byte
 }
 
-func NewUserService(repo repository.UserRepository, logger *zap.Logger) UserService {
+func NewUserService(repo repository.UserRepository, history repository.PasswordHistoryRepository, refreshTokens repository.RefreshTokenRepository, tx repository.TxManager, verification VerificationService, resets repository.PasswordResetRepository, m mailer.Mailer, auditLog repository.AuditRepository, logger *zap.Logger, clk clock.Clock, cfg *config.AppConfig, authCfg *config.AuthConfig, lockouts *lockout.Tracker) UserService {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), authCfg.BcryptCost)
+	if err != nil {
+		logger.Error("failed to generate dummy password hash", zap.Error(err))
+	}
+
 	return &DefaultUserService{
-		repo:   repo,
-		logger: logger,
+		repo:          repo,
+		history:       history,
+		refreshTokens: refreshTokens,
+		tx:            tx,
+		verification:  verification,
+		resets:        resets,
+		mailer:        m,
+		auditLog:      auditLog,
+		logger:        logger,
+		clock:         clk,
+		cfg:           cfg,
+		authCfg:       authCfg,
+		lockouts:      lockouts,
+		dummyHash:     dummyHash,
 	}
 }
 
 func (s *DefaultUserService) CreateUser(ctx context.Context, req CreateUserRequest) (*UserResponse, error) {
-	_, err := s.repo.GetByEmail(ctx, req.Email)
-	if err == nil {
-		return nil, ErrUserAlreadyExists
-	} else if !errors.Is(err, repository.ErrNotFound) {
+	req.Email = normalizeEmail(req.Email)
+
+	if taken, err := s.repo.ExistsByEmail(ctx, req.Email); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrEmailTaken
+	}
+	if taken, err := s.repo.ExistsByUsername(ctx, req.Username); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrUsernameTaken
+	}
+
+	if err := s.checkPasswordPolicy(req.Password); err != nil {
 		return nil, err
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), s.authCfg.BcryptCost)
 	if err != nil {
 		s.logger.Error("failed to hash password", zap.Error(err))
 		return nil, err
@@ -84,19 +212,109 @@ func (s *DefaultUserService) CreateUser(ctx context.Context, req CreateUserReque
 		PasswordHash: string(hashedPassword),
 		FirstName:    req.FirstName,
 		LastName:     req.LastName,
-		IsActive:     true,
+		IsActive:     s.cfg.ActivationMode == config.ActivationAlwaysActive,
+		Role:         models.RoleUser,
 	}
 
-	if err := s.repo.Create(ctx, user); err != nil {
+	ctx = ctxkeys.WithRequestTime(ctx, s.clock.Now())
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := txRepo.Create(ctx, user); err != nil {
+			return err
+		}
+		if err := s.recordPassword(ctx, user); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserCreate, user.ID, diffUsers(nil, user))
+	})
+	if err != nil {
 		if errors.Is(err, repository.ErrConflict) {
-			return nil, ErrUserAlreadyExists
+			return nil, conflictToServiceError(err)
 		}
 		return nil, err
 	}
 
+	// The account exists either way; a failed send is logged rather than
+	// failing the signup
+	if s.cfg.ActivationMode == config.ActivationRequireVerification {
+		if err := s.verification.SendVerification(ctx, user.ID, user.Email); err != nil {
+			s.logger.Error("failed to send verification email", zap.Uint("user_id", user.ID), zap.Error(err))
+		}
+	}
+
 	return s.mapUserToResponse(user), nil
 }
 
+func (s *DefaultUserService) CreateUsers(ctx context.Context, reqs []CreateUserRequest) ([]BulkCreateResult, error) {
+	results := make([]BulkCreateResult, len(reqs))
+
+	// Users failing the checks done before the insert are left out of the batch;
+	// pending maps each batched user back to its request
+	var users []*models.User
+	var pending []int
+	for i, req := range reqs {
+		if err := s.checkPasswordPolicy(req.Password); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), s.authCfg.BcryptCost)
+		if err != nil {
+			s.logger.Error("failed to hash password", zap.Error(err))
+			return nil, err
+		}
+
+		users = append(users, &models.User{
+			Username:     req.Username,
+			Email:        normalizeEmail(req.Email),
+			PasswordHash: string(hashedPassword),
+			FirstName:    req.FirstName,
+			LastName:     req.LastName,
+			IsActive:     s.cfg.ActivationMode == config.ActivationAlwaysActive,
+			Role:         models.RoleUser,
+		})
+		pending = append(pending, i)
+	}
+
+	ctx = ctxkeys.WithRequestTime(ctx, s.clock.Now())
+	err := s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		for j, err := range txRepo.CreateBatch(ctx, users) {
+			if err != nil {
+				if errors.Is(err, repository.ErrConflict) {
+					err = conflictToServiceError(err)
+				}
+				results[pending[j]].Err = err
+				continue
+			}
+			if err := s.recordPassword(ctx, users[j]); err != nil {
+				return err
+			}
+			if err := s.audit(ctx, models.AuditUserCreate, users[j].ID, diffUsers(nil, users[j])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for j, user := range users {
+		result := &results[pending[j]]
+		if result.Err != nil {
+			continue
+		}
+		result.User = s.mapUserToResponse(user)
+
+		if s.cfg.ActivationMode == config.ActivationRequireVerification {
+			if err := s.verification.SendVerification(ctx, user.ID, user.Email); err != nil {
+				s.logger.Error("failed to send verification email", zap.Uint("user_id", user.ID), zap.Error(err))
+			}
+		}
+	}
+
+	return results, nil
+}
+
 func (s *DefaultUserService) GetUser(ctx context.Context, id uint) (*UserResponse, error) {
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
@@ -109,27 +327,57 @@ func (s *DefaultUserService) GetUser(ctx context.Context, id uint) (*UserRespons
 	return s.mapUserToResponse(user), nil
 }
 
-func (s *DefaultUserService) ListUsers(ctx context.Context, page, pageSize int) ([]*UserResponse, int64, error) {
-	if page < 1 {
-		page = 1
+func (s *DefaultUserService) ListUsers(ctx context.Context, paginator pagination.Paginator) ([]*UserResponse, int64, error) {
+	users, count, err := s.repo.List(ctx, paginator.Offset(), paginator.Limit())
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if pageSize < 1 {
-		pageSize = 10
+	var userResponse []*UserResponse
+	for _, user := range users {
+		userResponse = append(userResponse, s.mapUserToResponse(user))
 	}
 
-	offset := (page - 1) * pageSize
-	users, count, err := s.repo.List(ctx, offset, pageSize)
+	return userResponse, count, nil
+}
+
+func (s *DefaultUserService) ListDeletedUsers(ctx context.Context, paginator pagination.Paginator) ([]*DeletedUserResponse, int64, error) {
+	users, count, err := s.repo.ListDeleted(ctx, paginator.Offset(), paginator.Limit())
 	if err != nil {
 		return nil, 0, err
 	}
 
-	var userResponse []*UserResponse
+	var deletedUsers []*DeletedUserResponse
+	for _, user := range users {
+		deletedUsers = append(deletedUsers, &DeletedUserResponse{
+			UserResponse: *s.mapUserToResponse(user),
+			DeletedAt:    user.DeletedAt.Time.UTC(),
+		})
+	}
+
+	return deletedUsers, count, nil
+}
+
+func (s *DefaultUserService) ListUsersAfter(ctx context.Context, after *pagination.Cursor, limit int) ([]*UserResponse, *pagination.Cursor, error) {
+	// Fetch one extra row to learn whether another page follows
+	users, err := s.repo.ListAfter(ctx, after, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *pagination.Cursor
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[len(users)-1]
+		next = &pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	userResponse := make([]*UserResponse, 0, len(users))
 	for _, user := range users {
 		userResponse = append(userResponse, s.mapUserToResponse(user))
 	}
 
-	return userResponse, count, nil
+	return userResponse, next, nil
 }
 
 func (s *DefaultUserService) UpdateUser(ctx context.Context, id uint, req UpdateUserRequest) (*UserResponse, error) {
@@ -141,13 +389,26 @@ func (s *DefaultUserService) UpdateUser(ctx context.Context, id uint, req Update
 		return nil, err
 	}
 
+	if req.IfUpdatedAt != nil && !slices.ContainsFunc(req.IfUpdatedAt, user.UpdatedAt.Equal) {
+		return nil, ErrPreconditionFailed
+	}
+
+	before := *user
+
 	// Update fields
 	user.FirstName = req.FirstName
 	user.LastName = req.LastName
 
 	// Update password if provided
 	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err := s.checkPasswordPolicy(req.Password); err != nil {
+			return nil, err
+		}
+		if err := s.checkPasswordReuse(ctx, user, req.Password); err != nil {
+			return nil, err
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), s.authCfg.BcryptCost)
 		if err != nil {
 			s.logger.Error("Failed to hash password", zap.Error(err))
 			return nil, err
@@ -155,7 +416,32 @@ func (s *DefaultUserService) UpdateUser(ctx context.Context, id uint, req Update
 		user.PasswordHash = string(hashedPassword)
 	}
 
-	if err := s.repo.Update(ctx, user); err != nil {
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if req.IfUpdatedAt != nil {
+			// Compare-and-set on updated_at locks the row, so a concurrent
+			// update either finished before and fails this, or waits and
+			// fails its own
+			changed, err := txRepo.UpdateWhere(ctx, id,
+				map[string]interface{}{"updated_at": s.clock.Now().UTC()},
+				map[string]interface{}{"updated_at": before.UpdatedAt})
+			if err != nil {
+				return err
+			}
+			if changed == 0 {
+				return ErrPreconditionFailed
+			}
+		}
+		if err := txRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		if req.Password != "" {
+			if err := s.recordPassword(ctx, user); err != nil {
+				return err
+			}
+		}
+		return s.audit(ctx, models.AuditUserUpdate, user.ID, diffUsers(&before, user))
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -163,7 +449,12 @@ func (s *DefaultUserService) UpdateUser(ctx context.Context, id uint, req Update
 }
 
 func (s *DefaultUserService) DeleteUser(ctx context.Context, id uint) error {
-	err := s.repo.Delete(ctx, id)
+	err := s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := txRepo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserDelete, id, nil)
+	})
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
 			return ErrUserNotFound
@@ -173,33 +464,389 @@ func (s *DefaultUserService) DeleteUser(ctx context.Context, id uint) error {
 	return nil
 }
 
-func (s *DefaultUserService) AuthenticateUser(ctx context.Context, email, password string) (*UserResponse, error) {
-	user, err := s.repo.GetByEmail(ctx, email)
+func (s *DefaultUserService) PurgeUser(ctx context.Context, id uint) error {
+	if _, err := s.repo.GetByIDWithDeleted(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	err := s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		// The rows go with the user via ON DELETE CASCADE; revoking first makes
+		// sure no session outlives the user should the cascade ever be dropped
+		if err := s.refreshTokens.RevokeAllForUser(ctx, id, s.clock.Now()); err != nil {
+			return err
+		}
+		if err := txRepo.HardDelete(ctx, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserPurge, id, nil)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	s.logger.Info("user purged", zap.Uint("user_id", id))
+	return nil
+}
+
+// RestoreUser undoes a soft delete. Restoring a user that isn't deleted is a
+// no-op; one whose email or username now belongs to a live user is refused
+// with ErrEmailTaken or ErrUsernameTaken.
+func (s *DefaultUserService) RestoreUser(ctx context.Context, id uint) (*UserResponse, error) {
+	user, err := s.repo.GetByIDWithDeleted(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if !user.DeletedAt.Valid {
+		return s.mapUserToResponse(user), nil
+	}
+
+	// The user is still soft-deleted here, so these only see other users
+	if taken, err := s.repo.ExistsByEmail(ctx, user.Email); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrEmailTaken
+	}
+	if taken, err := s.repo.ExistsByUsername(ctx, user.Username); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrUsernameTaken
+	}
+
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := txRepo.Restore(ctx, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserRestore, id, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrConflict):
+			return nil, conflictToServiceError(err)
+		case errors.Is(err, repository.ErrNotFound):
+			// Restored concurrently; report the current state below
+		default:
+			return nil, err
+		}
+	}
+
+	s.logger.Info("user restored", zap.Uint("user_id", id))
+	return s.GetUser(ctx, id)
+}
+
+// AuthenticateUser verifies the password of the user identified by email, or by
+// username when the identifier doesn't look like an email address
+func (s *DefaultUserService) AuthenticateUser(ctx context.Context, identifier, password string) (*UserResponse, error) {
+	var user *models.User
+	var err error
+	if strings.Contains(identifier, "@") {
+		user, err = s.repo.GetByEmail(ctx, identifier)
+	} else {
+		user, err = s.repo.GetByUsername(ctx, identifier)
+	}
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
+			// Spend the same bcrypt time as a real check to avoid user enumeration
+			_ = bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
 			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 
-	// Check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err := s.checkPassword(user, password); err != nil {
+		return nil, err
+	}
+
+	// Only reveal the account state once the caller has proven they own it
+	if !user.IsActive {
+		if s.cfg.ActivationMode == config.ActivationRequireVerification && user.EmailVerifiedAt == nil {
+			return nil, ErrEmailNotVerified
+		}
+		return nil, ErrAccountInactive
+	}
+
+	// Retention is measured from the last login, so a failure here is worth a
+	// warning but shouldn't block the login itself. No one is authenticated
+	// yet, so the user is recorded as acting on their own account.
+	err = s.tx.WithTransaction(ctxkeys.WithUserID(ctx, user.ID), func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := txRepo.UpdateLastLogin(ctx, user.ID, s.clock.Now()); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserLogin, user.ID, nil)
+	})
 	if err != nil {
-		return nil, ErrInvalidCredentials
+		s.logger.Warn("failed to record login", zap.Uint("user_id", user.ID), zap.Error(err))
 	}
 
+	s.upgradePasswordHash(ctx, user, password)
+
 	return s.mapUserToResponse(user), nil
 }
 
+// VerifyPassword re-confirms the password of an already authenticated user
+// before a sensitive action. Failures count towards the same lockout as logins.
+func (s *DefaultUserService) VerifyPassword(ctx context.Context, id uint, password string) error {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return s.checkPassword(user, password)
+}
+
+func (s *DefaultUserService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.resets.Store(ctx, &models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: s.clock.Now().Add(s.authCfg.PasswordResetTTL),
+	}); err != nil {
+		return err
+	}
+
+	// Sent in the background so the response takes about as long whether or
+	// not the address is registered
+	go func(ctx context.Context) {
+		link := s.cfg.PasswordResetURL + "?" + url.Values{"token": {token}}.Encode()
+		err := s.mailer.Send(ctx, mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password of your " + s.cfg.Name + " account. Open this link to choose a new one:\n\n" +
+				link + "\n\nThe link expires in " + s.authCfg.PasswordResetTTL.String() + ". If you didn't ask for this, ignore this email.\n",
+		})
+		if err != nil {
+			s.logger.Error("failed to send password reset email", zap.Uint("user_id", user.ID), zap.Error(err))
+		}
+	}(context.WithoutCancel(ctx))
+
+	return nil
+}
+
+func (s *DefaultUserService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	reset, err := s.resets.Lookup(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if reset.ConsumedAt != nil {
+		return ErrInvalidResetToken
+	}
+	now := s.clock.Now()
+	if !now.Before(reset.ExpiresAt) {
+		return ErrResetTokenExpired
+	}
+
+	user, err := s.repo.GetByID(ctx, reset.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if err := s.checkPasswordPolicy(newPassword); err != nil {
+		return err
+	}
+	if err := s.checkPasswordReuse(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), s.authCfg.BcryptCost)
+	if err != nil {
+		s.logger.Error("Failed to hash password", zap.Error(err))
+		return err
+	}
+	user.PasswordHash = string(hashedPassword)
+
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := s.resets.Consume(ctx, reset.ID, now); err != nil {
+			return err
+		}
+		// Any other links sent before this reset are void now
+		if err := s.resets.ConsumeAllForUser(ctx, user.ID, now); err != nil {
+			return err
+		}
+		if _, err := txRepo.UpdateWhere(ctx, user.ID, map[string]interface{}{"password_hash": user.PasswordHash}, nil); err != nil {
+			return err
+		}
+		if err := s.recordPassword(ctx, user); err != nil {
+			return err
+		}
+		if err := s.refreshTokens.RevokeAllForUser(ctx, user.ID, now); err != nil {
+			return err
+		}
+		return s.audit(ctx, models.AuditUserPasswordReset, user.ID, map[string]auditChange{
+			"password_hash": {From: auditRedacted, To: auditRedacted},
+		})
+	})
+	if err != nil {
+		// Consumed concurrently by another request
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	s.lockouts.Reset(user.ID)
+	s.logger.Info("password reset", zap.Uint("user_id", user.ID))
+	return nil
+}
+
+// checkPassword compares password with the user's hash, refusing locked
+// accounts and counting failures towards a lockout
+func (s *DefaultUserService) checkPassword(user *models.User, password string) error {
+	if _, locked := s.lockouts.Locked(user.ID); locked {
+		return ErrAccountLocked
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		if s.lockouts.Fail(user.ID) {
+			s.logger.Warn("Account locked after repeated failed password checks", zap.Uint("user_id", user.ID))
+		}
+		return ErrInvalidCredentials
+	}
+
+	s.lockouts.Reset(user.ID)
+	return nil
+}
+
+// upgradePasswordHash rehashes a just-verified password whose hash predates
+// the configured bcrypt cost. It's best effort: the old hash stays valid, so
+// a failure only postpones the upgrade to the next login.
+func (s *DefaultUserService) upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+	if err != nil || cost >= s.authCfg.BcryptCost {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), s.authCfg.BcryptCost)
+	if err != nil {
+		s.logger.Warn("failed to rehash password", zap.Uint("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	// Conditioned on the old hash so a concurrent password change isn't undone
+	if _, err := s.repo.UpdateWhere(ctx, user.ID,
+		map[string]interface{}{"password_hash": string(hashedPassword)},
+		map[string]interface{}{"password_hash": user.PasswordHash},
+	); err != nil {
+		s.logger.Warn("failed to store rehashed password", zap.Uint("user_id", user.ID), zap.Error(err))
+		return
+	}
+	s.logger.Info("Upgraded password hash cost", zap.Uint("user_id", user.ID), zap.Int("from", cost), zap.Int("to", s.authCfg.BcryptCost))
+}
+
+// checkPasswordPolicy returns a WeakPasswordError if password breaks any rule
+// of the configured policy
+func (s *DefaultUserService) checkPasswordPolicy(pw string) error {
+	cfg := s.authCfg.PasswordPolicy
+	policy := password.Policy{
+		MinLength:     cfg.MinLength,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+		RejectCommon:  cfg.RejectCommon,
+	}
+	if violations := policy.Check(pw); len(violations) > 0 {
+		return &WeakPasswordError{Violations: violations}
+	}
+	return nil
+}
+
+// checkPasswordReuse returns ErrPasswordReused if password matches the current
+// password or one of the recent ones kept in the history
+func (s *DefaultUserService) checkPasswordReuse(ctx context.Context, user *models.User, password string) error {
+	if s.authCfg.PasswordHistory <= 0 {
+		return nil
+	}
+
+	hashes, err := s.history.Recent(ctx, user.ID, s.authCfg.PasswordHistory)
+	if err != nil {
+		return err
+	}
+
+	// The current hash is checked too, for accounts created before the history existed
+	for _, hash := range append(hashes, user.PasswordHash) {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// recordPassword adds the user's current hash to the history and drops entries
+// beyond the configured depth. It runs in the same transaction that saves the
+// password, so the two can't diverge.
+func (s *DefaultUserService) recordPassword(ctx context.Context, user *models.User) error {
+	if s.authCfg.PasswordHistory <= 0 {
+		return nil
+	}
+
+	if err := s.history.Add(ctx, user.ID, user.PasswordHash); err != nil {
+		return err
+	}
+	return s.history.Prune(ctx, user.ID, s.authCfg.PasswordHistory)
+}
+
+// normalizeEmail lowercases email so addresses differing only in case are
+// stored and compared as the same
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// conflictToServiceError tells the caller which of the user's unique fields is
+// already in use
+func conflictToServiceError(err error) error {
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		switch conflict.Field {
+		case "email":
+			return ErrEmailTaken
+		case "username":
+			return ErrUsernameTaken
+		}
+	}
+	return ErrUserAlreadyExists
+}
+
 func (s *DefaultUserService) mapUserToResponse(user *models.User) *UserResponse {
 	return &UserResponse{
-		ID:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		IsActive:  user.IsActive,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:               user.ID,
+		Username:         user.Username,
+		Email:            user.Email,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		IsActive:         user.IsActive,
+		Role:             user.Role,
+		TwoFactorEnabled: user.TOTPEnabled,
+		// Rows read back from the database are in the local zone; responses
+		// always use UTC
+		CreatedAt: user.CreatedAt.UTC(),
+		UpdatedAt: user.UpdatedAt.UTC(),
 	}
 }
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"go_postgres/internal/clock"
+	"go_postgres/internal/config"
+	"go_postgres/internal/mailer"
+	"go_postgres/internal/models"
+	"go_postgres/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrEmailNotVerified         = errors.New("email address is not verified")
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+	ErrVerificationTokenExpired = errors.New("verification token has expired")
+)
+
+type VerificationService interface {
+	// SendVerification emails the user a link that activates their account
+	SendVerification(ctx context.Context, userID uint, email string) error
+	// VerifyEmail consumes the token and activates the user it was sent to
+	VerifyEmail(ctx context.Context, token string) error
+}
+
+type DefaultVerificationService struct {
+	users         repository.UserRepository
+	verifications repository.EmailVerificationRepository
+	tx            repository.TxManager
+	mailer        mailer.Mailer
+	logger        *zap.Logger
+	clock         clock.Clock
+	cfg           *config.AuthConfig
+	appCfg        *config.AppConfig
+}
+
+func NewVerificationService(users repository.UserRepository, verifications repository.EmailVerificationRepository, tx repository.TxManager, m mailer.Mailer, logger *zap.Logger, clk clock.Clock, cfg *config.AuthConfig, appCfg *config.AppConfig) VerificationService {
+	return &DefaultVerificationService{
+		users:         users,
+		verifications: verifications,
+		tx:            tx,
+		mailer:        m,
+		logger:        logger,
+		clock:         clk,
+		cfg:           cfg,
+		appCfg:        appCfg,
+	}
+}
+
+func (s *DefaultVerificationService) SendVerification(ctx context.Context, userID uint, email string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifications.Store(ctx, &models.EmailVerification{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: s.clock.Now().Add(s.cfg.EmailVerificationTTL),
+	}); err != nil {
+		return err
+	}
+
+	link := s.appCfg.BaseURL + "/api/auth/verify?" + url.Values{"token": {token}}.Encode()
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Open this link to verify your email address and activate your " + s.appCfg.Name + " account:\n\n" +
+			link + "\n\nThe link expires in " + s.cfg.EmailVerificationTTL.String() + ". If you didn't sign up, ignore this email.\n",
+	})
+}
+
+func (s *DefaultVerificationService) VerifyEmail(ctx context.Context, token string) error {
+	stored, err := s.verifications.Lookup(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		return err
+	}
+
+	if stored.ConsumedAt != nil {
+		return ErrInvalidVerificationToken
+	}
+	now := s.clock.Now()
+	if !now.Before(stored.ExpiresAt) {
+		return ErrVerificationTokenExpired
+	}
+
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context, txRepo repository.UserRepository) error {
+		if err := s.verifications.Consume(ctx, stored.ID, now); err != nil {
+			return err
+		}
+
+		rows, err := txRepo.UpdateWhere(ctx, stored.UserID,
+			map[string]interface{}{"is_active": true, "email_verified_at": now},
+			nil,
+		)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		// Consumed concurrently, or the user was deleted since
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		return err
+	}
+
+	s.logger.Info("email verified", zap.Uint("user_id", stored.UserID))
+	return nil
+}
@@ -0,0 +1,68 @@
+package timing
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Breakdown accumulates named sub-timings (auth, db, ...) for a single request.
+// It is safe for concurrent use.
+type Breakdown struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	counts    map[string]int
+}
+
+func NewBreakdown() *Breakdown {
+	return &Breakdown{
+		durations: make(map[string]time.Duration),
+		counts:    make(map[string]int),
+	}
+}
+
+// Add records one occurrence of the named component taking d
+func (b *Breakdown) Add(name string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.durations[name] += d
+	b.counts[name]++
+}
+
+// Durations returns the total time spent per component
+func (b *Breakdown) Durations() map[string]time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.durations)
+}
+
+// Counts returns how many times each component was recorded
+func (b *Breakdown) Counts() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.counts)
+}
+
+type breakdownKey struct{}
+
+// WithBreakdown returns a context that accumulates sub-timings into b
+func WithBreakdown(ctx context.Context, b *Breakdown) context.Context {
+	return context.WithValue(ctx, breakdownKey{}, b)
+}
+
+// FromContext returns the request's breakdown, or nil if timings aren't collected
+func FromContext(ctx context.Context) *Breakdown {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(breakdownKey{}).(*Breakdown)
+	return b
+}
+
+// Track records d under name when the context collects timings
+func Track(ctx context.Context, name string, d time.Duration) {
+	if b := FromContext(ctx); b != nil {
+		b.Add(name, d)
+	}
+}
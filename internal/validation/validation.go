@@ -0,0 +1,102 @@
+// Package validation checks request structs against their `validate` tags.
+//
+// Supported rules, comma-separated:
+//
+//	required  the value must not be empty
+//	min=N     strings must have at least N characters
+//	max=N     strings must have at most N characters
+//	email     strings must be a bare email address
+//
+// Rules other than required are skipped for empty values, so optional fields
+// are only checked when present.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors maps a field's JSON name to a message describing what's wrong with it
+type Errors map[string]string
+
+// Validate checks the exported fields of the struct v (or pointer to one) and
+// returns one message per invalid field; the result is empty when v is valid.
+// It panics on a malformed tag, which is a programming error.
+func Validate(v interface{}) Errors {
+	errs := Errors{}
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+
+		if msg := check(value.Field(i), tag); msg != "" {
+			errs[fieldName(field)] = msg
+		}
+	}
+
+	return errs
+}
+
+// check applies the rules in tag to value and returns the first failure
+func check(value reflect.Value, tag string) string {
+	empty := value.IsZero()
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+
+		if name == "required" {
+			if empty {
+				return "is required"
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		switch name {
+		case "min":
+			if n := mustAtoi(rule, arg); utf8.RuneCountInString(value.String()) < n {
+				return fmt.Sprintf("must be at least %d characters", n)
+			}
+		case "max":
+			if n := mustAtoi(rule, arg); utf8.RuneCountInString(value.String()) > n {
+				return fmt.Sprintf("must be at most %d characters", n)
+			}
+		case "email":
+			// ParseAddress also accepts "Name <addr>"; only the bare address is valid here
+			addr, err := mail.ParseAddress(value.String())
+			if err != nil || addr.Address != value.String() {
+				return "must be a valid email address"
+			}
+		default:
+			panic("validation: unknown rule " + strconv.Quote(rule))
+		}
+	}
+	return ""
+}
+
+// fieldName returns the name clients know the field by
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func mustAtoi(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic("validation: invalid rule " + strconv.Quote(rule))
+	}
+	return n
+}
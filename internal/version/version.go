@@ -0,0 +1,5 @@
+package version
+
+// Version identifies the running build. It is set at build time with
+// -ldflags "-X go_postgres/internal/version.Version=v1.2.3"
+var Version = "dev"